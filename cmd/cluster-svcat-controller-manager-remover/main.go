@@ -1,131 +1,199 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	operatorapiv1 "github.com/openshift/api/operator/v1"
 	configclient "github.com/openshift/client-go/config/clientset/versioned"
 	operatorclient "github.com/openshift/client-go/operator/clientset/versioned"
-	operatorv1 "github.com/openshift/client-go/operator/clientset/versioned/typed/operator/v1"
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
 	log "github.com/sirupsen/logrus"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-var targetNamespaceName = "openshift-service-catalog-controller-manager-operator"
+// clientFlags configure the connection to the cluster.
+type clientFlags struct {
+	kubeconfig string
+	context    string
+	qps        float32
+	burst      int
+}
+
+func (c *clientFlags) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&c.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file. Defaults to $KUBECONFIG, then ~/.kube/config, then the in-cluster config.")
+	flags.StringVar(&c.context, "context", "", "The kubeconfig context to use.")
+	flags.Float32Var(&c.qps, "kube-api-qps", rest.DefaultQPS, "Queries per second to the API server.")
+	flags.IntVar(&c.burst, "kube-api-burst", rest.DefaultBurst, "Burst of queries to the API server.")
+}
 
-func createClientConfigFromFile(configPath string) (*rest.Config, error) {
-	clientConfig, err := clientcmd.LoadFromFile(configPath)
+// loadClientConfig returns the client config following the kubeconfig loading
+// rules of kubectl, falling back to the in-cluster config when there is no
+// kubeconfig.
+func (c *clientFlags) loadClientConfig() (*rest.Config, error) {
+	loader := clientcmd.NewDefaultClientConfigLoadingRules()
+	loader.ExplicitPath = c.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loader, &clientcmd.ConfigOverrides{CurrentContext: c.context})
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
+	config.QPS = c.qps
+	config.Burst = c.burst
+	return config, nil
+}
 
-	config, err := clientcmd.NewDefaultClientConfig(*clientConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+// newRemover builds the clients of the remover from the client config.
+func newRemover(client *clientFlags, options remover.Options) (*remover.Remover, error) {
+	clientConfig, err := client.loadClientConfig()
 	if err != nil {
+		log.Errorf("Failed to create LocalClientSet: %v", err)
 		return nil, err
 	}
-	return config, nil
-}
+	clientConfig.Wrap(remover.NewTracingRoundTripper)
 
-func deleteTargetNamespace(kubeClient *kubernetes.Clientset, target string) {
-	log.Infof("Removing target namespace %s", target)
-	if err := kubeClient.CoreV1().Namespaces().Delete(target, nil); err != nil && !apierrors.IsNotFound(err) {
-		log.Errorf("problem removing target namespace [%s] :  %v", target, err)
+	kubeClient, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		log.Errorf("problem getting kube client, error %v", err)
+		return nil, err
 	}
-}
 
-func deleteCustomResource(client operatorv1.OperatorV1Interface) {
-	log.Info("Removing the ServiceCatalogControllerManager CR")
-	err := client.ServiceCatalogControllerManagers().Delete("cluster", &metav1.DeleteOptions{})
+	dynamicClient, err := dynamic.NewForConfig(clientConfig)
 	if err != nil {
-		log.Errorf("ServiceCatalogControllerManager cr deletion failed: %v", err)
-	} else {
-		log.Info("ServiceCatalogControllerManager cr removed successfully.")
+		log.Errorf("problem getting dynamic client, error %v", err)
+		return nil, err
+	}
+
+	operatorClient, err := operatorclient.NewForConfig(clientConfig)
+	if err != nil {
+		log.Errorf("problem getting operator client, error %v", err)
+		return nil, err
 	}
-}
 
-func deleteClusterOperator(clientConfig *rest.Config) {
 	configClient, err := configclient.NewForConfig(clientConfig)
 	if err != nil {
 		log.Errorf("problem getting config client, error %v", err)
+		return nil, err
 	}
 
-	log.Info("Removing the service-catalog-controller-manager clusteroperator")
-	err = configClient.ConfigV1().ClusterOperators().Delete("service-catalog-controller-manager", &metav1.DeleteOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		log.Errorf("problem removing cluster operator [service-catalog-controller-manager] :  %v", err)
-	}
+	return remover.New(kubeClient, operatorClient.OperatorV1(), configClient.ConfigV1(), dynamicClient, options), nil
 }
 
-func deleteClusterRolesAndBindings(kubeClient *kubernetes.Clientset) {
-	log.Info("Removing ClusterRoleBinding: openshift-service-catalog-controller-manager-operator")
-	err := kubeClient.RbacV1().ClusterRoleBindings().Delete("openshift-service-catalog-controller-manager-operator", &metav1.DeleteOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		log.Errorf("problem removing cluster role binding [openshift-service-catalog-controller-manager-operator] :  %v", err)
-	}
+// subcommands maps the name of every subcommand to the function running it
+// with its arguments and returning the process exit code. Without a
+// subcommand, when the first argument is a flag or there is none, the remover
+// removes Service Catalog, as the remover job does.
+var subcommands = map[string]func(args []string) int{
+	"plan":    runPlan,
+	"remove":  runRemove,
+	"verify":  runVerify,
+	"status":  runStatus,
+	"restore": runRestore,
+}
 
-	log.Info("Removing ClusterRole: openshift-service-catalog-controller-manager-operator")
-	err = kubeClient.RbacV1().ClusterRoles().Delete("openshift-service-catalog-controller-manager-operator", &metav1.DeleteOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		log.Errorf("problem removing cluster role [openshift-service-catalog-controller-manager-operator] :  %v", err)
+// lookupSubcommand returns the name of the subcommand the arguments, without
+// the program name, run and the arguments left for it.
+func lookupSubcommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "remove", args, nil
 	}
+	if _, ok := subcommands[args[0]]; !ok {
+		return "", nil, fmt.Errorf("unknown subcommand %q, expected plan, remove, verify, status or restore", args[0])
+	}
+	return args[0], args[1:], nil
 }
 
 func main() {
-	log.Info("Starting openshift-service-catalog-controller-manager-remover job")
-
-	clientConfig, err := rest.InClusterConfig()
+	name, args, err := lookupSubcommand(os.Args[1:])
 	if err != nil {
-		clientConfig, err = createClientConfigFromFile(homedir.HomeDir() + "/.kube/config")
-		if err != nil {
-			log.Error("Failed to create LocalClientSet")
-			panic(err.Error())
-		}
+		log.Error(err)
+		os.Exit(remover.ExitFailed)
 	}
+	os.Exit(subcommands[name](args))
+}
 
-	kubeClient, err := kubernetes.NewForConfig(clientConfig)
-	if err != nil {
-		panic(err.Error())
+// parseFlags parses the flags of a subcommand, none of which takes
+// arguments: anything left over, such as a subcommand given after a flag,
+// is a mistake the remover must not run past.
+func parseFlags(flags *pflag.FlagSet, args []string) bool {
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		log.Errorf("unexpected arguments %v: subcommands go before any flag", flags.Args())
+		return false
 	}
+	return true
+}
 
-	operatorClient, err := operatorclient.NewForConfig(clientConfig)
-	if err != nil {
-		log.Errorf("problem getting operator client, error %v", err)
-	}
-	operatorConfigClient := operatorClient.OperatorV1()
-	operatorConfig, err := operatorConfigClient.ServiceCatalogControllerManagers().Get("cluster", metav1.GetOptions{})
-	if apierrors.IsNotFound(err) {
-		log.Info("ServiceCatalogControllerManager cr has already been removed.")
-		deleteTargetNamespace(kubeClient, targetNamespaceName)
-		deleteClusterOperator(clientConfig)
-		deleteClusterRolesAndBindings(kubeClient)
-		os.Exit(0)
-	} else if err != nil {
-		log.Errorf("problem getting ServiceCatalogControllerManage CR, error %v", err)
-	}
+// commonFlags are the flags of every subcommand: how to connect to the
+// cluster, the names of the Service Catalog objects, retries and logging.
+type commonFlags struct {
+	client    clientFlags
+	options   remover.Options
+	logLevel  string
+	logFormat string
+}
 
-	// Handle the various ManagementStates
-	switch operatorConfig.Spec.ManagementState {
-	case operatorapiv1.Managed:
-		log.Warning("We found a cluster-svcat-controller-manager-operator in Managed state. Aborting")
-	case operatorapiv1.Unmanaged:
-		log.Info("ServiceCatalogControllerManager managementState is 'Unmanaged'")
-		deleteTargetNamespace(kubeClient, targetNamespaceName)
-		deleteCustomResource(operatorConfigClient)
-		deleteClusterOperator(clientConfig)
-		deleteClusterRolesAndBindings(kubeClient)
-	case operatorapiv1.Removed:
-		log.Info("ServiceCatalogControllerManager managementState is 'Removed'")
-		deleteTargetNamespace(kubeClient, targetNamespaceName)
-		deleteCustomResource(operatorConfigClient)
-		deleteClusterOperator(clientConfig)
-		deleteClusterRolesAndBindings(kubeClient)
-	default:
-		log.Error("Unknown managementState")
+func newCommonFlags(flags *pflag.FlagSet) *commonFlags {
+	c := &commonFlags{options: remover.DefaultOptions()}
+	c.client.addFlags(flags)
+	options := &c.options
+	flags.StringVar(&options.CRName, "cr-name", "", "Name of the ServiceCatalogControllerManager and ServiceCatalogAPIServer CRs, cluster by default.")
+	flags.StringVar(&options.TargetNamespace, "target-namespace", "", "Namespace of the Service Catalog controller manager operator, openshift-service-catalog-controller-manager-operator by default.")
+	flags.StringVar(&options.OperandNamespace, "operand-namespace", "", "Namespace of the Service Catalog controller manager, openshift-service-catalog-controller-manager by default.")
+	flags.StringVar(&options.ClusterOperatorName, "clusteroperator-name", "", "ClusterOperator of the Service Catalog controller manager, service-catalog-controller-manager by default.")
+	flags.StringVar(&options.RBACName, "rbac-name", "", "ClusterRole and ClusterRoleBinding of the Service Catalog controller manager operator, openshift-service-catalog-controller-manager-operator by default.")
+	flags.StringVar(&options.APIServerTargetNamespace, "apiserver-target-namespace", "", "Namespace of the Service Catalog API server operator, openshift-service-catalog-apiserver-operator by default.")
+	flags.StringVar(&options.APIServerOperandNamespace, "apiserver-operand-namespace", "", "Namespace of the Service Catalog API server, openshift-service-catalog-apiserver by default.")
+	flags.StringVar(&options.APIServerClusterOperatorName, "apiserver-clusteroperator-name", "", "ClusterOperator of the Service Catalog API server, service-catalog-apiserver by default.")
+	flags.StringVar(&options.APIServerRBACName, "apiserver-rbac-name", "", "ClusterRole and ClusterRoleBinding of the Service Catalog API server operator, openshift-service-catalog-apiserver-operator by default.")
+	flags.StringVar(&options.InventoryConfigMap, "inventory-configmap", "", "ConfigMap in the openshift-service-catalog-removed namespace to read the inventory of what to remove from instead of the inventory the remover embeds. It may only remove objects of Service Catalog.")
+	flags.IntVar(&options.RetryPolicy.Attempts, "retry-attempts", options.RetryPolicy.Attempts, "Maximum number of times an API call failing with a transient error is made.")
+	flags.DurationVar(&options.RetryPolicy.InitialBackoff, "retry-initial-backoff", options.RetryPolicy.InitialBackoff, "Wait before the first retry of an API call.")
+	flags.Float64Var(&options.RetryPolicy.BackoffFactor, "retry-backoff-factor", options.RetryPolicy.BackoffFactor, "Factor each following retry wait is multiplied by.")
+	flags.DurationVar(&options.RetryPolicy.MaxBackoff, "retry-max-backoff", options.RetryPolicy.MaxBackoff, "Longest wait between two retries of an API call.")
+	flags.Float64Var(&options.RetryPolicy.Jitter, "retry-jitter", options.RetryPolicy.Jitter, "Randomly lengthen every retry wait by up to this factor.")
+	flags.DurationVar(&options.RetryPolicy.Timeout, "timeout", options.RetryPolicy.Timeout, "Overall deadline, 0 for none. Steps not done by then fail.")
+	flags.StringVar(&c.logLevel, "log-level", "", "Log level, Normal, Debug, Trace or TraceAll, instead of the operatorLogLevel or logLevel of the operator CRs. Trace and TraceAll log every API request, TraceAll with its body unless it is about Secrets.")
+	flags.StringVar(&c.logFormat, "log-format", "text", "Format of the logs: text or json. JSON entries of the removal steps carry their phase, kind, name, namespace, attempt and outcome as fields.")
+	return c
+}
+
+// removalFlags are the flags deciding whether and how Service Catalog is
+// removed, of the plan and remove subcommands.
+type removalFlags struct {
+	managedPolicy string
+}
+
+func newRemovalFlags(flags *pflag.FlagSet, options *remover.Options) *removalFlags {
+	f := &removalFlags{}
+	flags.BoolVar(&options.Force, "force", false, "Remove Service Catalog even though tenants still have service instances or bindings, which are lost.")
+	flags.BoolVar(&options.KeepClusterOperator, "keep-clusteroperator", false, "Report the removal through the Progressing and Degraded conditions of the ClusterOperators, deleting them last and only if everything else was removed.")
+	flags.StringVar(&f.managedPolicy, "managed-policy", string(options.ManagedPolicy), "What to do while Service Catalog is still Managed: abort, wait for an admin to switch it to Removed, or remove it anyway.")
+	flags.DurationVar(&options.ManagedPollInterval, "managed-poll-interval", options.ManagedPollInterval, "How often the operator CRs are checked with --managed-policy=wait.")
+	flags.DurationVar(&options.ManagedWaitTimeout, "managed-wait-timeout", 0, "How long to wait with --managed-policy=wait, 0 for no limit.")
+	return f
+}
+
+func (f *removalFlags) apply(options *remover.Options) {
+	options.ManagedPolicy = remover.ManagedPolicy(f.managedPolicy)
+}
+
+// newRemover sets up logging and builds the remover from the common flags,
+// once they are parsed. It logs why it fails.
+func (c *commonFlags) newRemover() (*remover.Remover, error) {
+	if err := remover.SetLogFormat(c.logFormat); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	c.options.LogLevel = operatorapiv1.LogLevel(c.logLevel)
+	if err := c.options.Validate(); err != nil {
+		log.Error(err)
+		return nil, err
 	}
-	log.Info("The openshift-service-catalog-controller-manater-remover job has finished.")
+	return newRemover(&c.client, c.options)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLookupSubcommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		expected      string
+		expectedArgs  []string
+		expectedError string
+	}{
+		{name: "no arguments", args: []string{}, expected: "remove", expectedArgs: []string{}},
+		{name: "only flags", args: []string{"--force", "--timeout=1m"}, expected: "remove", expectedArgs: []string{"--force", "--timeout=1m"}},
+		{name: "plan", args: []string{"plan", "--log-format=json"}, expected: "plan", expectedArgs: []string{"--log-format=json"}},
+		{name: "remove", args: []string{"remove"}, expected: "remove", expectedArgs: []string{}},
+		{name: "verify", args: []string{"verify"}, expected: "verify", expectedArgs: []string{}},
+		{name: "status", args: []string{"status"}, expected: "status", expectedArgs: []string{}},
+		{name: "restore", args: []string{"restore", "--backup-id=20200101-000000-abcde"}, expected: "restore", expectedArgs: []string{"--backup-id=20200101-000000-abcde"}},
+		{name: "unknown subcommand", args: []string{"delete", "--force"}, expectedError: `unknown subcommand "delete"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, args, err := lookupSubcommand(test.args)
+
+			if test.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+					t.Errorf("expected error %q, got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != test.expected {
+				t.Errorf("expected subcommand %s, got %s", test.expected, name)
+			}
+			if !reflect.DeepEqual(args, test.expectedArgs) {
+				t.Errorf("expected arguments %v, got %v", test.expectedArgs, args)
+			}
+			if _, ok := subcommands[name]; !ok {
+				t.Errorf("expected subcommand %s to run", name)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected bool
+	}{
+		{name: "no arguments", args: []string{}, expected: true},
+		{name: "flags", args: []string{"--force", "--log-format", "json"}, expected: true},
+		{name: "subcommand after a flag", args: []string{"--force", "plan"}, expected: false},
+		{name: "positional argument", args: []string{"leftover"}, expected: false},
+		{name: "arguments after --", args: []string{"--", "--force"}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.Bool("force", false, "")
+			flags.String("log-format", "text", "")
+
+			if parsed := parseFlags(flags, test.args); parsed != test.expected {
+				t.Errorf("expected parseFlags to return %v, got %v", test.expected, parsed)
+			}
+		})
+	}
+}
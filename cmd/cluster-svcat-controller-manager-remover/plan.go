@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
+	"github.com/spf13/pflag"
+)
+
+// runPlan implements the plan subcommand: it prints what the remove
+// subcommand would remove, validated with a server-side dry run, without
+// removing anything.
+func runPlan(args []string) int {
+	var output string
+	flags := pflag.NewFlagSet("plan", pflag.ExitOnError)
+	common := newCommonFlags(flags)
+	removal := newRemovalFlags(flags, &common.options)
+	flags.StringVarP(&output, "output", "o", "text", "Format of the removal plan: text or json.")
+	if !parseFlags(flags, args) {
+		return remover.ExitFailed
+	}
+	removal.apply(&common.options)
+
+	r, err := common.newRemover()
+	if err != nil {
+		return remover.ExitFailed
+	}
+	return printPlan(r, output)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// runRemove implements the remove subcommand, which the remover job runs: it
+// removes Service Catalog and reports how it went. --dry-run is kept as an
+// alias of the plan subcommand.
+func runRemove(args []string) int {
+	var dryRun bool
+	var dryRunOutput, terminationMessagePath string
+	flags := pflag.NewFlagSet("remove", pflag.ExitOnError)
+	common := newCommonFlags(flags)
+	options := &common.options
+	removal := newRemovalFlags(flags, options)
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the removal plan, like the plan subcommand, without removing anything.")
+	flags.StringVarP(&dryRunOutput, "output", "o", "text", "Format of the removal plan printed by --dry-run: text or json.")
+	flags.StringVar(&terminationMessagePath, "termination-message-path", "/dev/termination-log", "File the JSON removal report is written to when the job finishes.")
+	flags.BoolVar(&options.WaitForDeletion, "wait", false, "Wait for deleted namespaces and CRs to disappear, reporting the ones that are stuck.")
+	flags.DurationVar(&options.DeletionTimeout, "wait-timeout", options.DeletionTimeout, "How long to wait for each deleted namespace or CR to disappear, more than 0.")
+	flags.BoolVar(&options.Backup, "backup", options.Backup, "Back up every object before removing anything. The removal is aborted if the backup fails. Restore with the restore subcommand.")
+	flags.StringVar(&options.BackupDir, "backup-dir", "", "Directory to write the backup to, instead of Secrets in the "+remover.StateNamespaceName+" namespace.")
+	flags.IntVar(&options.BackupsKept, "backups-kept", options.BackupsKept, "Number of backups kept in Secrets of the "+remover.StateNamespaceName+" namespace, the older ones being deleted, 0 to keep them all.")
+	if !parseFlags(flags, args) {
+		return remover.ExitFailed
+	}
+	removal.apply(options)
+
+	r, err := common.newRemover()
+	if err != nil {
+		return remover.ExitFailed
+	}
+	if dryRun {
+		return printPlan(r, dryRunOutput)
+	}
+
+	log.Info("Starting openshift-service-catalog-controller-manager-remover job")
+	report := r.Run()
+	if err := report.WriteTerminationMessage(terminationMessagePath); err != nil {
+		log.Warningf("problem writing the removal report to %s: %v", terminationMessagePath, err)
+	}
+	log.Info("The openshift-service-catalog-controller-manager-remover job has finished.")
+	return report.ExitCode()
+}
+
+// printPlan prints the removal plan in the given format, text or json.
+func printPlan(r *remover.Remover, output string) int {
+	plan, err := r.Plan()
+	if err != nil {
+		log.Errorf("problem building the removal plan: %v", err)
+		return remover.ExitFailed
+	}
+	if err := remover.PrintPlan(os.Stdout, plan, output); err != nil {
+		log.Errorf("problem printing the removal plan: %v", err)
+		return remover.ExitFailed
+	}
+	return remover.ExitSucceeded
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// runRestore implements the restore subcommand: it re-applies a backup taken
+// by a previous removal.
+func runRestore(args []string) int {
+	var backupFile, backupID string
+	flags := pflag.NewFlagSet("restore", pflag.ExitOnError)
+	common := newCommonFlags(flags)
+	flags.StringVar(&backupFile, "backup-file", "", "Restore the backup bundle stored in this file, written by a removal run with --backup-dir.")
+	flags.StringVar(&backupID, "backup-id", "", "Restore the backup with this id from the Secrets of the "+remover.StateNamespaceName+" namespace, the latest one if empty.")
+	if !parseFlags(flags, args) {
+		return remover.ExitFailed
+	}
+
+	r, err := common.newRemover()
+	if err != nil {
+		return remover.ExitFailed
+	}
+	log.Info("Starting openshift-service-catalog-controller-manager-remover restore")
+	report, err := r.Restore(backupFile, backupID)
+	if err != nil {
+		log.Error(err)
+		return remover.ExitFailed
+	}
+	return report.ExitCode()
+}
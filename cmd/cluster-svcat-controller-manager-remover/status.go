@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// runStatus implements the status subcommand: it prints the report of the
+// last removal and exits with the exit code that removal had.
+func runStatus(args []string) int {
+	var output string
+	flags := pflag.NewFlagSet("status", pflag.ExitOnError)
+	common := newCommonFlags(flags)
+	flags.StringVarP(&output, "output", "o", "text", "Format of the removal report: text or json.")
+	if !parseFlags(flags, args) {
+		return remover.ExitFailed
+	}
+
+	r, err := common.newRemover()
+	if err != nil {
+		return remover.ExitFailed
+	}
+	report, err := r.LastReport()
+	if err != nil {
+		log.Error(err)
+		return remover.ExitFailed
+	}
+	if err := remover.PrintReport(os.Stdout, report, output); err != nil {
+		log.Errorf("problem printing the removal report: %v", err)
+		return remover.ExitFailed
+	}
+	return report.ExitCode()
+}
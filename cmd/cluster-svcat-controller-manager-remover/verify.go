@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/openshift/cluster-svcat-controller-manager-operator/pkg/remover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// runVerify implements the verify subcommand: it prints the checklist telling
+// whether anything of Service Catalog remains, failing unless every check
+// passes.
+func runVerify(args []string) int {
+	var output string
+	flags := pflag.NewFlagSet("verify", pflag.ExitOnError)
+	common := newCommonFlags(flags)
+	flags.StringVarP(&output, "output", "o", "text", "Format of the checklist: text or json.")
+	if !parseFlags(flags, args) {
+		return remover.ExitFailed
+	}
+
+	r, err := common.newRemover()
+	if err != nil {
+		return remover.ExitFailed
+	}
+	verification, err := r.Verify()
+	if err != nil {
+		log.Errorf("problem verifying the removal: %v", err)
+		return remover.ExitFailed
+	}
+	if err := remover.PrintVerification(os.Stdout, verification, output); err != nil {
+		log.Errorf("problem printing the checklist: %v", err)
+		return remover.ExitFailed
+	}
+	if !verification.Passed {
+		return remover.ExitFailed
+	}
+	return remover.ExitSucceeded
+}
@@ -0,0 +1,24 @@
+#!/bin/bash -e
+# Embeds the removal inventory in the remover, which works from it unless
+# given an inventory ConfigMap. Run it whenever the inventory changes;
+# hack/verify-generated-inventory.sh checks it was.
+pushd $( readlink -f "$( dirname "${0}" )/.." ) > /dev/null
+
+inventory=pkg/remover/inventory.yaml
+output=${1:-pkg/remover/zz_generated.inventory.go}
+
+if grep -q '`' "${inventory}"; then
+    (>&2 echo "!!! ${inventory} cannot contain backquotes")
+    exit 1
+fi
+
+{
+    echo "// Code generated by hack/update-generated-inventory.sh. DO NOT EDIT."
+    echo
+    echo "package remover"
+    echo
+    echo "// defaultInventory is ${inventory}."
+    echo "const defaultInventory = \`$(cat "${inventory}")"
+    echo "\`"
+} > "${output}"
+gofmt -s -w "${output}"
@@ -0,0 +1,10 @@
+#!/bin/bash -e
+pushd $( readlink -f "$( dirname "${0}" )/.." ) > /dev/null
+
+generated=$(mktemp)
+trap "rm -f ${generated}" EXIT
+hack/update-generated-inventory.sh "${generated}"
+if ! diff -u pkg/remover/zz_generated.inventory.go "${generated}"; then
+    (>&2 echo "!!! the embedded removal inventory is out of date, run hack/update-generated-inventory.sh")
+    exit 1
+fi
@@ -0,0 +1,588 @@
+package remover
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// backupBundleVersion is the version of the backupBundle format. Restore
+	// refuses bundles of any other version.
+	backupBundleVersion = "v1"
+
+	// Backups are stored, gzipped and split in chunks, in Secrets of
+	// StateNamespaceName: they may contain the Secrets of the removed
+	// namespaces.
+	backupSecretPrefix     = "service-catalog-removal-backup-"
+	backupIDLabel          = "servicecatalog.openshift.io/removal-backup"
+	backupChunkAnnotation  = "servicecatalog.openshift.io/removal-backup-chunk"
+	backupChunksAnnotation = "servicecatalog.openshift.io/removal-backup-chunks"
+	backupSecretKey        = "bundle.json.gz"
+	// backupChunkSize keeps every Secret well below the 1MiB object size
+	// limit.
+	backupChunkSize = 768 * 1024
+
+	restoreFieldManager    = "service-catalog-remover-restore"
+	actionBackup           = "Backup"
+	actionRestore          = "Restore"
+	actionWaitForAvailable = "WaitForAvailable"
+)
+
+// apiServiceAvailableTimeout is how long a restored APIService is waited for
+// to become Available, checked every apiServicePollInterval.
+var (
+	apiServiceAvailableTimeout = 5 * time.Minute
+	apiServicePollInterval     = 2 * time.Second
+)
+
+// backupObject is a single object of a backup bundle, along with the
+// resource it was read from.
+type backupObject struct {
+	// Phase is the phase of the inventory the object was removed in, that
+	// of its namespace for the contents of a namespace.
+	Phase    string                     `json:"phase,omitempty"`
+	Group    string                     `json:"group,omitempty"`
+	Version  string                     `json:"version"`
+	Resource string                     `json:"resource"`
+	Object   *unstructured.Unstructured `json:"object"`
+}
+
+func (o backupObject) groupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: o.Group, Version: o.Version, Resource: o.Resource}
+}
+
+// backupBundle holds every object the remover is about to remove, including
+// the contents of the namespaces it removes.
+type backupBundle struct {
+	Version   string      `json:"version"`
+	ID        string      `json:"id"`
+	CreatedAt metav1.Time `json:"createdAt"`
+	// Incomplete lists what could not be backed up, such as API groups that
+	// could not be discovered in a removed namespace.
+	Incomplete []string       `json:"incomplete,omitempty"`
+	Objects    []backupObject `json:"objects"`
+}
+
+// skipBackup tells whether a namespaced object does not need to be backed
+// up: it is owned by, and would be recreated from, another object, or is
+// generated by the cluster.
+func skipBackup(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) bool {
+	if len(obj.GetOwnerReferences()) > 0 {
+		return true
+	}
+	switch gvr.GroupResource() {
+	case schema.GroupResource{Resource: "events"}, schema.GroupResource{Group: "events.k8s.io", Resource: "events"}:
+		return true
+	case schema.GroupResource{Resource: "secrets"}:
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType == string(corev1.SecretTypeServiceAccountToken)
+	}
+	return false
+}
+
+// collectBackup reads every existing object of the planned removals, and the
+// contents of the namespaces they remove, into a new bundle.
+func (r *Remover) collectBackup(removals []PlannedRemoval) (*backupBundle, error) {
+	now := metav1.Now()
+	bundle := &backupBundle{
+		Version: backupBundleVersion,
+		// the random suffix keeps apart removals started in the same second
+		ID:        now.UTC().Format("20060102-150405") + "-" + utilrand.String(5),
+		CreatedAt: now,
+	}
+	for _, removal := range removals {
+		if !removal.Exists {
+			continue
+		}
+		gvr := removal.groupVersionResource()
+		var obj *unstructured.Unstructured
+		err := r.retryOnTransientError(func() (err error) {
+			obj, err = resourceClient(r.dynamicClient, gvr, removal.Namespace).Get(removal.Name, metav1.GetOptions{})
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("problem reading %s: %v", describeObject(removal.Kind, removal.Namespace, removal.Name), err)
+		}
+		bundle.Objects = append(bundle.Objects, backupObject{Phase: removal.Phase, Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource, Object: obj})
+
+		if gvr != namespaceResource {
+			continue
+		}
+		objects, failures, err := r.listNamespaceObjects(removal.Name)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading the contents of namespace %s: %v", removal.Name, err)
+		}
+		for _, failure := range failures {
+			log.Warningf("namespace %s cannot be backed up completely: %s", removal.Name, failure)
+			bundle.Incomplete = append(bundle.Incomplete, fmt.Sprintf("namespace %s: %s", removal.Name, failure))
+		}
+		for i := range objects {
+			if skipBackup(objects[i].gvr, &objects[i].object) {
+				continue
+			}
+			bundle.Objects = append(bundle.Objects, backupObject{
+				Phase:    removal.Phase,
+				Group:    objects[i].gvr.Group,
+				Version:  objects[i].gvr.Version,
+				Resource: objects[i].gvr.Resource,
+				Object:   &objects[i].object,
+			})
+		}
+	}
+	return bundle, nil
+}
+
+func encodeBackup(bundle *backupBundle) ([]byte, error) {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeBackup(data []byte) (*backupBundle, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	data, err = ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &backupBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, err
+	}
+	if bundle.Version != backupBundleVersion {
+		return nil, fmt.Errorf("unsupported backup bundle version %q, expected %q", bundle.Version, backupBundleVersion)
+	}
+	return bundle, nil
+}
+
+func backupFileName(id string) string {
+	return backupSecretPrefix + id + ".json.gz"
+}
+
+// writeNewFile writes data to a file that must not exist yet, so that a
+// backup never overwrites another.
+func writeNewFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeBackup stores the bundle in dir if set, otherwise in Secrets of
+// StateNamespaceName. It returns where the bundle was stored.
+func (r *Remover) writeBackup(bundle *backupBundle, dir string) (string, error) {
+	data, err := encodeBackup(bundle)
+	if err != nil {
+		return "", err
+	}
+	if len(dir) > 0 {
+		path := filepath.Join(dir, backupFileName(bundle.ID))
+		return path, writeNewFile(path, data)
+	}
+
+	chunks := (len(data) + backupChunkSize - 1) / backupChunkSize
+	for i := 0; i < chunks; i++ {
+		end := (i + 1) * backupChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s%s-%d", backupSecretPrefix, bundle.ID, i),
+				Namespace: StateNamespaceName,
+				Labels:    map[string]string{backupIDLabel: bundle.ID},
+				Annotations: map[string]string{
+					backupChunkAnnotation:  strconv.Itoa(i),
+					backupChunksAnnotation: strconv.Itoa(chunks),
+				},
+			},
+			Data: map[string][]byte{backupSecretKey: data[i*backupChunkSize : end]},
+		}
+		// Create fails with AlreadyExists rather than overwrite another backup
+		err := r.retryOnTransientError(func() error {
+			_, err := r.kubeClient.CoreV1().Secrets(StateNamespaceName).Create(secret)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Secrets %s/%s%s-*", StateNamespaceName, backupSecretPrefix, bundle.ID), nil
+}
+
+// pruneBackups deletes the Secrets of every backup but the latest
+// Options.BackupsKept.
+func (r *Remover) pruneBackups() error {
+	if r.options.BackupsKept == 0 {
+		return nil
+	}
+	var secrets *corev1.SecretList
+	err := r.retryOnTransientError(func() (err error) {
+		secrets, err = r.kubeClient.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: backupIDLabel})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	backups := map[string][]string{}
+	var ids []string
+	for _, secret := range secrets.Items {
+		id := secret.Labels[backupIDLabel]
+		if _, ok := backups[id]; !ok {
+			ids = append(ids, id)
+		}
+		backups[id] = append(backups[id], secret.Name)
+	}
+	if len(ids) <= r.options.BackupsKept {
+		return nil
+	}
+	// IDs start with a timestamp, the oldest sort first
+	sort.Strings(ids)
+	for _, id := range ids[:len(ids)-r.options.BackupsKept] {
+		log.Infof("Deleting backup %s, only the latest %d are kept", id, r.options.BackupsKept)
+		for _, name := range backups[id] {
+			err := r.retryOnTransientError(func() error {
+				return r.kubeClient.CoreV1().Secrets(StateNamespaceName).Delete(name, nil)
+			})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readBackup loads a bundle from file if set, otherwise from the Secrets of
+// StateNamespaceName: the bundle with the given id, or the latest one if
+// id is empty.
+func (r *Remover) readBackup(file, id string) (*backupBundle, error) {
+	if len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return decodeBackup(data)
+	}
+
+	selector := backupIDLabel
+	if len(id) > 0 {
+		selector = backupIDLabel + "=" + id
+	}
+	var secrets *corev1.SecretList
+	err := r.retryOnTransientError(func() (err error) {
+		secrets, err = r.kubeClient.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: selector})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets.Items) == 0 {
+		return nil, fmt.Errorf("no backup found in namespace %s", StateNamespaceName)
+	}
+	if len(id) == 0 {
+		// IDs start with a timestamp, the latest sorts last
+		for _, secret := range secrets.Items {
+			if secret.Labels[backupIDLabel] > id {
+				id = secret.Labels[backupIDLabel]
+			}
+		}
+	}
+
+	chunks := map[int][]byte{}
+	total := 0
+	for _, secret := range secrets.Items {
+		if secret.Labels[backupIDLabel] != id {
+			continue
+		}
+		index, err := strconv.Atoi(secret.Annotations[backupChunkAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("backup Secret %s has an invalid chunk index: %v", secret.Name, err)
+		}
+		total, err = strconv.Atoi(secret.Annotations[backupChunksAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("backup Secret %s has an invalid chunk count: %v", secret.Name, err)
+		}
+		chunks[index] = secret.Data[backupSecretKey]
+	}
+	var data []byte
+	for i := 0; i < total; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil, fmt.Errorf("backup %s is missing chunk %d of %d", id, i, total)
+		}
+		data = append(data, chunk...)
+	}
+	return decodeBackup(data)
+}
+
+// backupExists tells whether the backup with the given id, stored at
+// location, can still be read.
+func (r *Remover) backupExists(id, location string) bool {
+	var secrets *corev1.SecretList
+	err := r.retryOnTransientError(func() (err error) {
+		secrets, err = r.kubeClient.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: backupIDLabel + "=" + id})
+		return err
+	})
+	if err == nil && len(secrets.Items) > 0 {
+		return true
+	}
+	// stored with Options.BackupDir
+	_, err = os.Stat(location)
+	return err == nil
+}
+
+// retriedBackup returns the id and location of the backup of the last
+// removal when it failed and its backup is still there: the Job retrying it
+// finds a cluster that removal already partly emptied, whose backup would
+// miss what was removed.
+func (r *Remover) retriedBackup() (id, location string) {
+	last, err := r.LastReport()
+	if err != nil {
+		log.Debugf("no backup of a previous removal to keep: %v", err)
+		return "", ""
+	}
+	if last.Outcome == ReportSucceeded || len(last.BackupID) == 0 || !r.backupExists(last.BackupID, last.BackupLocation) {
+		return "", ""
+	}
+	return last.BackupID, last.BackupLocation
+}
+
+// backupBeforeRemoval backs up every object of the inventory the removal is
+// about to remove and records the outcome in the report. When the removal
+// retries a failed one, the backup that one took is kept instead.
+func (r *Remover) backupBeforeRemoval(report *Report, inv *inventory) error {
+	if id, location := r.retriedBackup(); len(id) > 0 {
+		return report.track("Backup", "", "removal", actionBackup, func() error {
+			log.Infof("Keeping backup %s at %s, taken by the failed removal this one retries", id, location)
+			report.setBackup(id, location)
+			return nil
+		})
+	}
+	return report.track("Backup", "", "removal", actionBackup, func() error {
+		removals, err := r.planRemovals(inv)
+		if err != nil {
+			return err
+		}
+		bundle, err := r.collectBackup(removals)
+		if err != nil {
+			return err
+		}
+		location, err := r.writeBackup(bundle, r.options.BackupDir)
+		if err != nil {
+			// not retried as a whole: the bundle may be partially written
+			return fmt.Errorf("problem writing the backup: %v", err)
+		}
+		log.Infof("Backed up %d objects to %s", len(bundle.Objects), location)
+		report.setBackup(bundle.ID, location)
+		if len(r.options.BackupDir) == 0 {
+			if err := r.pruneBackups(); err != nil {
+				log.Warningf("problem deleting old backups: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// restorePriority orders the objects of a phase for restore: namespaces and
+// API registrations before the objects that need them.
+func restorePriority(gvr schema.GroupVersionResource) int {
+	switch gvr {
+	case namespaceResource:
+		return 0
+	case crdResource:
+		return 1
+	case apiServiceResource:
+		return 2
+	}
+	return 3
+}
+
+// restoreOrder orders the objects of a bundle, listed in removal order, for
+// restore: the phases in reverse, so that for one an API server runs again
+// before its objects are restored, and within a phase the objects in reverse
+// too, the things consumed before their consumers, but for restorePriority.
+func restoreOrder(objects []backupObject) []backupObject {
+	var phases [][]backupObject
+	for i, object := range objects {
+		if i == 0 || object.Phase != objects[i-1].Phase {
+			phases = append(phases, nil)
+		}
+		phases[len(phases)-1] = append(phases[len(phases)-1], object)
+	}
+
+	var ordered []backupObject
+	for i := len(phases) - 1; i >= 0; i-- {
+		var phase []backupObject
+		for j := len(phases[i]) - 1; j >= 0; j-- {
+			phase = append(phase, phases[i][j])
+		}
+		sort.SliceStable(phase, func(i, j int) bool {
+			return restorePriority(phase[i].groupVersionResource()) < restorePriority(phase[j].groupVersionResource())
+		})
+		ordered = append(ordered, phase...)
+	}
+	return ordered
+}
+
+// restoreConflictError is returned when restoring an object would overwrite
+// fields changed since the backup.
+type restoreConflictError struct {
+	err error
+}
+
+func (e *restoreConflictError) Error() string {
+	return fmt.Sprintf("conflicts with the object in the cluster, not restored: %v", e.err)
+}
+
+// prepareForRestore strips the fields the API server sets, which cannot be
+// applied, and the owner references, whose owners no longer exist.
+func prepareForRestore(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	for _, field := range []string{"resourceVersion", "uid", "selfLink", "creationTimestamp", "generation", "managedFields", "deletionTimestamp", "deletionGracePeriodSeconds", "ownerReferences"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
+
+// restoreObject server-side applies the object without forcing, so that the
+// API server rejects it if it conflicts with changes made since the backup.
+func restoreObject(dynamicClient dynamic.Interface, object backupObject) error {
+	obj := prepareForRestore(object.Object)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	force := false
+	_, err = resourceClient(dynamicClient, object.groupVersionResource(), obj.GetNamespace()).Patch(obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: restoreFieldManager, Force: &force})
+	if apierrors.IsConflict(err) {
+		return &restoreConflictError{err: err}
+	}
+	return err
+}
+
+// isAPIServiceAvailable tells whether the Available condition of the
+// APIService is True.
+func isAPIServiceAvailable(apiService *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if ok && condition["type"] == "Available" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForAPIService polls the APIService until it is Available or
+// apiServiceAvailableTimeout passes.
+func (r *Remover) waitForAPIService(name string) error {
+	var last *unstructured.Unstructured
+	var lastErr error
+	err := wait.PollImmediate(apiServicePollInterval, apiServiceAvailableTimeout, func() (bool, error) {
+		apiService, err := r.dynamicClient.Resource(apiServiceResource).Get(name, metav1.GetOptions{})
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		last = apiService
+		return isAPIServiceAvailable(apiService), nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	if last == nil {
+		return fmt.Errorf("not available after %v, last error: %v", apiServiceAvailableTimeout, lastErr)
+	}
+	return fmt.Errorf("not available after %v", apiServiceAvailableTimeout)
+}
+
+// restoreBackup re-applies every object of the bundle, in restoreOrder, and
+// records the outcome of each in the report. The objects of an API group
+// whose APIService was restored are only restored once it is Available: until
+// then the API server serving them answers with errors.
+func (r *Remover) restoreBackup(bundle *backupBundle, report *Report) {
+	// apiServices are the restored APIServices of every API group not yet
+	// Available
+	apiServices := map[string][]string{}
+	for _, object := range restoreOrder(bundle.Objects) {
+		object := object
+		for _, name := range apiServices[object.Group] {
+			name := name
+			report.track("APIService", "", name, actionWaitForAvailable, func() error {
+				return r.waitForAPIService(name)
+			})
+		}
+		delete(apiServices, object.Group)
+
+		err := report.track(object.Object.GetKind(), object.Object.GetNamespace(), object.Object.GetName(), actionRestore, func() error {
+			return restoreObject(r.dynamicClient, object)
+		})
+		if err != nil || object.groupVersionResource() != apiServiceResource {
+			continue
+		}
+		if group, _, _ := unstructured.NestedString(object.Object.Object, "spec", "group"); len(group) > 0 {
+			apiServices[group] = append(apiServices[group], object.Object.GetName())
+		}
+	}
+}
+
+// Restore re-applies a backup taken by a previous removal: the bundle stored
+// in file if set, otherwise the bundle with the given id in the Secrets of
+// StateNamespaceName, the latest one if id is empty. It returns the report of
+// the restore, which unlike the report of a removal is not persisted.
+func (r *Remover) Restore(file, id string) (*Report, error) {
+	r.applyLogLevel()
+	bundle, err := r.readBackup(file, id)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading the backup: %v", err)
+	}
+	log.Infof("Restoring %d objects from backup %s taken at %s", len(bundle.Objects), bundle.ID, bundle.CreatedAt.Format(time.RFC3339))
+	for _, incomplete := range bundle.Incomplete {
+		log.Warningf("the backup is incomplete, %s", incomplete)
+	}
+
+	report := r.newReport()
+	r.restoreBackup(bundle, report)
+	report.complete()
+	log.Infof("Restore %s: %s", report.Outcome, report.Message)
+	return report, nil
+}
@@ -0,0 +1,322 @@
+package remover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	clients.kube.Resources = append(clients.kube.Resources, &metav1.APIResourceList{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"get", "list"}},
+			{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: metav1.Verbs{"get", "list"}},
+		},
+	})
+	var objects []runtime.Object
+	for _, c := range []component{controllerManager, apiServer} {
+		objects = append(objects,
+			newObject("v1", "Namespace", "", c.operatorNamespace),
+			newObject("v1", "Namespace", "", c.operandNamespace),
+		)
+	}
+	settings := newObject("v1", "ConfigMap", controllerManager.operatorNamespace, "settings")
+	settings.SetResourceVersion("42")
+	unstructured.SetNestedField(settings.Object, "value", "data", "key")
+	credentials := newObject("v1", "Secret", controllerManager.operandNamespace, "credentials")
+	unstructured.SetNestedField(credentials.Object, string(corev1.SecretTypeOpaque), "type")
+	// generated by the cluster, or from another object: not backed up
+	token := newObject("v1", "Secret", controllerManager.operandNamespace, "token")
+	unstructured.SetNestedField(token.Object, string(corev1.SecretTypeServiceAccountToken), "type")
+	owned := newObject("v1", "ConfigMap", controllerManager.operandNamespace, "owned")
+	owned.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "controller-manager", UID: "uid"}})
+	clients.dynamic = newFakeDynamicClient(append(objects, settings, credentials, token, owned)...)
+	// the namespaces are deleted through the typed client, and waited for
+	// through the dynamic one
+	clients.kube.PrependReactor("delete", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return false, nil, clients.dynamic.Resource(namespaceResource).Delete(action.(clienttesting.DeleteAction).GetName(), nil)
+	})
+	options := testOptions()
+	options.Backup = true
+
+	report := clients.remover(options).Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Fatalf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	secrets, err := clients.kube.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: backupIDLabel})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets.Items) == 0 {
+		t.Fatalf("expected the backup to be stored in Secrets of namespace %s", StateNamespaceName)
+	}
+
+	// the cluster, emptied by the removal, only keeps the backup
+	clients.dynamic = newFakeDynamicClient()
+	var applied []*unstructured.Unstructured
+	clients.dynamic.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			t.Errorf("expected a server-side apply, got a %s patch", patch.GetPatchType())
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		applied = append(applied, obj)
+		return true, obj, nil
+	})
+
+	restore, err := clients.remover(testOptions()).Restore("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if restore.Outcome != ReportSucceeded {
+		t.Errorf("expected restore outcome %s, got %s: %s", ReportSucceeded, restore.Outcome, restore.Message)
+	}
+	var restored []string
+	namespaces := map[string]int{}
+	for i, obj := range applied {
+		restored = append(restored, describeObject(obj.GetKind(), obj.GetNamespace(), obj.GetName()))
+		if obj.GetKind() == "Namespace" {
+			namespaces[obj.GetName()] = i
+		} else if _, ok := namespaces[obj.GetNamespace()]; !ok {
+			// the namespaces go before the objects in them
+			t.Errorf("expected namespace %s to be restored before %s", obj.GetNamespace(), obj.GetName())
+		}
+		if len(obj.GetResourceVersion()) > 0 {
+			t.Errorf("expected %s to be restored without its resourceVersion", obj.GetName())
+		}
+	}
+	// the phases go in reverse, the operands before their operators
+	if namespaces[apiServer.operandNamespace] > namespaces[apiServer.operatorNamespace] || namespaces[controllerManager.operandNamespace] > namespaces[controllerManager.operatorNamespace] {
+		t.Errorf("expected the operand namespaces to be restored before the operator namespaces, got %v", restored)
+	}
+	sort.Strings(restored)
+	expected := []string{
+		"ConfigMap " + controllerManager.operatorNamespace + "/settings",
+		"Namespace " + apiServer.operandNamespace,
+		"Namespace " + apiServer.operatorNamespace,
+		"Namespace " + controllerManager.operandNamespace,
+		"Namespace " + controllerManager.operatorNamespace,
+		"Secret " + controllerManager.operandNamespace + "/credentials",
+	}
+	if !reflect.DeepEqual(restored, expected) {
+		t.Errorf("expected to restore %v, restored %v", expected, restored)
+	}
+	for _, obj := range applied {
+		if obj.GetName() != "settings" {
+			continue
+		}
+		if value, _, _ := unstructured.NestedString(obj.Object, "data", "key"); value != "value" {
+			t.Errorf("expected ConfigMap settings to be restored with its data, got %v", obj.Object["data"])
+		}
+	}
+}
+
+func TestWriteBackupNeverOverwrites(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	r := clients.remover(testOptions())
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, dir := range []string{"", dir} {
+		// two removals started in the same second
+		first, err := r.collectBackup(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		second, err := r.collectBackup(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if first.ID == second.ID {
+			t.Errorf("expected backups to get different ids, both got %s", first.ID)
+		}
+		if _, err := r.writeBackup(first, dir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := r.writeBackup(second, dir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := r.writeBackup(first, dir); err == nil {
+			t.Errorf("expected writing backup %s to %q again to fail", first.ID, dir)
+		}
+	}
+}
+
+func TestRetriedRemovalKeepsBackup(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	clients.kube.PrependReactor("delete", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(namespaceResource.GroupResource(), action.(clienttesting.DeleteAction).GetName(), fmt.Errorf("denied"))
+	})
+	options := testOptions()
+	options.Backup = true
+
+	first := clients.remover(options).Run()
+	if first.Outcome != ReportPartiallyFailed || len(first.BackupID) == 0 {
+		t.Fatalf("expected outcome %s with a backup, got %s with backup %q: %s", ReportPartiallyFailed, first.Outcome, first.BackupID, first.Message)
+	}
+	retry := clients.remover(options).Run()
+
+	if retry.BackupID != first.BackupID || retry.BackupLocation != first.BackupLocation {
+		t.Errorf("expected the retry to keep backup %s at %s, got %s at %s", first.BackupID, first.BackupLocation, retry.BackupID, retry.BackupLocation)
+	}
+	secrets, err := clients.kube.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: backupIDLabel})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range secrets.Items {
+		if id := secret.Labels[backupIDLabel]; id != first.BackupID {
+			t.Errorf("expected only backup %s, got %s", first.BackupID, id)
+		}
+	}
+}
+
+func TestRestoreWaitsForAPIService(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		apiServiceAvailableTimeout, apiServicePollInterval = timeout, interval
+	}(apiServiceAvailableTimeout, apiServicePollInterval)
+	apiServiceAvailableTimeout, apiServicePollInterval = 100*time.Millisecond, time.Millisecond
+
+	backup := func(phase removalPhase, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) backupObject {
+		return backupObject{Phase: string(phase), Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource, Object: obj}
+	}
+	instanceResource := schema.GroupVersionResource{Group: serviceCatalogGroup, Version: "v1beta1", Resource: "serviceinstances"}
+	brokerResource := schema.GroupVersionResource{Group: serviceCatalogGroup, Version: "v1beta1", Resource: "clusterservicebrokers"}
+	// in removal order
+	bundle := &backupBundle{Objects: []backupObject{
+		backup("RemovingAPIResources", instanceResource, newServiceCatalogObject("ServiceInstance", "tenant", "database")),
+		backup("RemovingAPIResources", brokerResource, newServiceCatalogObject("ClusterServiceBroker", "", "broker")),
+		backup("RemovingAPIResources", apiServiceResource, newRegistration("apiregistration.k8s.io/v1", "APIService", "v1beta1."+serviceCatalogGroup)),
+		backup("RemovingAPIServer", namespaceResource, newObject("v1", "Namespace", "", apiServer.operandNamespace)),
+		backup("RemovingAPIServer", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, newObject("apps/v1", "Deployment", apiServer.operandNamespace, "apiserver")),
+	}}
+
+	tests := []struct {
+		name            string
+		available       bool
+		expectedOutcome StepOutcome
+	}{
+		{
+			name:            "available",
+			available:       true,
+			expectedOutcome: StepSucceeded,
+		},
+		{
+			name:            "never available",
+			expectedOutcome: StepFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			var applied []string
+			clients.dynamic.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				obj := &unstructured.Unstructured{}
+				if err := json.Unmarshal(action.(clienttesting.PatchAction).GetPatch(), &obj.Object); err != nil {
+					return true, nil, err
+				}
+				applied = append(applied, obj.GetName())
+				return true, obj, nil
+			})
+			polls := 0
+			clients.dynamic.PrependReactor("get", "apiservices", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				apiService := newRegistration("apiregistration.k8s.io/v1", "APIService", action.(clienttesting.GetAction).GetName())
+				// available once its API server had the time to start
+				polls++
+				if test.available && polls > 2 {
+					unstructured.SetNestedSlice(apiService.Object, []interface{}{map[string]interface{}{"type": "Available", "status": "True"}}, "status", "conditions")
+				}
+				return true, apiService, nil
+			})
+			r := clients.remover(testOptions())
+			report := r.newReport()
+
+			r.restoreBackup(bundle, report)
+
+			expected := []string{apiServer.operandNamespace, "apiserver", "v1beta1." + serviceCatalogGroup, "broker", "database"}
+			if !reflect.DeepEqual(applied, expected) {
+				t.Errorf("expected to restore %v in order, restored %v", expected, applied)
+			}
+			var steps []string
+			for _, step := range report.Steps {
+				steps = append(steps, step.Action+" "+step.Name)
+				if step.Action == actionWaitForAvailable && step.Outcome != test.expectedOutcome {
+					t.Errorf("expected the wait for the APIService to be %s, got %s: %s", test.expectedOutcome, step.Outcome, step.Error)
+				}
+			}
+			expectedSteps := []string{
+				actionRestore + " " + apiServer.operandNamespace,
+				actionRestore + " apiserver",
+				actionRestore + " v1beta1." + serviceCatalogGroup,
+				actionWaitForAvailable + " v1beta1." + serviceCatalogGroup,
+				actionRestore + " broker",
+				actionRestore + " database",
+			}
+			if !reflect.DeepEqual(steps, expectedSteps) {
+				t.Errorf("expected steps %v, got %v", expectedSteps, steps)
+			}
+		})
+	}
+}
+
+func TestBackupKeepsLatestBackups(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	for _, id := range []string{"20200101-000000-aaaaa", "20200102-000000-aaaaa", "20200103-000000-aaaaa"} {
+		for i := 0; i < 2; i++ {
+			_, err := clients.kube.CoreV1().Secrets(StateNamespaceName).Create(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s%s-%d", backupSecretPrefix, id, i),
+				Namespace: StateNamespaceName,
+				Labels:    map[string]string{backupIDLabel: id},
+			}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	options := testOptions()
+	options.Backup = true
+	options.BackupsKept = 2
+
+	report := clients.remover(options).Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Fatalf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	secrets, err := clients.kube.CoreV1().Secrets(StateNamespaceName).List(metav1.ListOptions{LabelSelector: backupIDLabel})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kept := map[string]bool{}
+	for _, secret := range secrets.Items {
+		kept[secret.Labels[backupIDLabel]] = true
+	}
+	expected := map[string]bool{"20200103-000000-aaaaa": true, report.BackupID: true}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected to keep backups %v, kept %v", expected, kept)
+	}
+}
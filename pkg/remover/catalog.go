@@ -0,0 +1,355 @@
+package remover
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// serviceCatalogGroup is the API group of Service Catalog.
+const serviceCatalogGroup = "servicecatalog.k8s.io"
+
+// apiGroup is an API group of the inventory: the remover removes every object
+// of it, followed by its APIService and CRD registrations.
+type apiGroup struct {
+	Name string `json:"name"`
+	// ResourceOrder is the order in which the resources of the group are
+	// removed, consumers before the things they consume. Resources served by
+	// the API but missing from it are removed last.
+	ResourceOrder []string `json:"resourceOrder,omitempty"`
+	// StripFinalizers are removed from the objects before they are deleted:
+	// once the controllers of the group are gone nothing else ever would.
+	StripFinalizers []string `json:"stripFinalizers,omitempty"`
+}
+
+var (
+	apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+	crdResource        = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+)
+
+// undiscoverableGroupError is returned by discoverServedResources for a group
+// that is registered but whose resources cannot be discovered, as happens
+// while its aggregated API server is down.
+type undiscoverableGroupError struct {
+	groupVersion string
+	err          error
+}
+
+func (e *undiscoverableGroupError) Error() string {
+	return fmt.Sprintf("API group version %s is registered but cannot be discovered: %v", e.groupVersion, e.err)
+}
+
+// discoverResources returns the deletable resources of the group currently
+// served by the cluster, sorted by its ResourceOrder. A group that is
+// registered but not served, as happens once its aggregated API server is
+// gone, yields no resources.
+func discoverResources(discoveryClient discovery.DiscoveryInterface, g apiGroup) ([]schema.GroupVersionResource, error) {
+	resources, err := discoverServedResources(discoveryClient, g)
+	if undiscoverable, ok := err.(*undiscoverableGroupError); ok {
+		log.Warningf("%v, its resources cannot be removed", undiscoverable)
+		return nil, nil
+	}
+	return resources, err
+}
+
+// discoverServedResources is discoverResources, failing with an
+// undiscoverableGroupError for a group that is registered but not served.
+func discoverServedResources(discoveryClient discovery.DiscoveryInterface, g apiGroup) ([]schema.GroupVersionResource, error) {
+	groups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var groupVersion string
+	for _, group := range groups.Groups {
+		if group.Name == g.Name {
+			groupVersion = group.PreferredVersion.GroupVersion
+			break
+		}
+	}
+	if len(groupVersion) == 0 {
+		log.Infof("API group %s is not served", g.Name)
+		return nil, nil
+	}
+
+	resourceList, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return nil, &undiscoverableGroupError{groupVersion: groupVersion, err: err}
+	}
+	gv, err := schema.ParseGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []schema.GroupVersionResource
+	for _, resource := range resourceList.APIResources {
+		// skip subresources such as serviceinstances/status
+		if strings.Contains(resource.Name, "/") {
+			continue
+		}
+		if !sets.NewString(resource.Verbs...).HasAll("list", "delete") {
+			continue
+		}
+		resources = append(resources, gv.WithResource(resource.Name))
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		return g.resourceOrderIndex(resources[i].Resource) < g.resourceOrderIndex(resources[j].Resource)
+	})
+	return resources, nil
+}
+
+func (g apiGroup) resourceOrderIndex(resource string) int {
+	for i, r := range g.ResourceOrder {
+		if r == resource {
+			return i
+		}
+	}
+	return len(g.ResourceOrder)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFinalizerToStrip tells whether obj carries any of the finalizers.
+func hasFinalizerToStrip(obj unstructured.Unstructured, finalizers []string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if containsString(finalizers, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripFinalizers removes the given finalizers, and only those, from the
+// given object.
+func stripFinalizers(client dynamic.ResourceInterface, name string, finalizers []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing := obj.GetFinalizers()
+		var remaining []string
+		for _, f := range existing {
+			if !containsString(finalizers, f) {
+				remaining = append(remaining, f)
+			}
+		}
+		if len(remaining) == len(existing) {
+			return nil
+		}
+		obj.SetFinalizers(remaining)
+		_, err = client.Update(obj, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func resourceClient(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace string) dynamic.ResourceInterface {
+	if len(namespace) == 0 {
+		return dynamicClient.Resource(gvr)
+	}
+	return dynamicClient.Resource(gvr).Namespace(namespace)
+}
+
+func objectName(obj unstructured.Unstructured) string {
+	if len(obj.GetNamespace()) == 0 {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// deleteGroupResource strips the orphaned finalizers from and deletes every
+// object of the given resource of the group.
+func (r *Remover) deleteGroupResource(g apiGroup, gvr schema.GroupVersionResource, report *Report) error {
+	var list *unstructured.UnstructuredList
+	err := report.track(gvr.Resource, "", "*", actionList, func() (err error) {
+		list, err = r.dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil || list == nil {
+		return err
+	}
+
+	var errs []error
+	for _, obj := range list.Items {
+		client := resourceClient(r.dynamicClient, gvr, obj.GetNamespace())
+		name := obj.GetName()
+		if len(g.StripFinalizers) > 0 {
+			err := report.track(obj.GetKind(), obj.GetNamespace(), name, actionStripFinalizer, func() error {
+				return stripFinalizers(client, name, g.StripFinalizers)
+			})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		err = report.track(obj.GetKind(), obj.GetNamespace(), name, actionDelete, func() error {
+			return client.Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// listRegistrations returns the objects of the given registration resource
+// (APIServices or CRDs) that belong to the API group.
+func listRegistrations(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, group string) ([]unstructured.Unstructured, error) {
+	list, err := dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var registrations []unstructured.Unstructured
+	for _, obj := range list.Items {
+		registered, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		if registered == group {
+			registrations = append(registrations, obj)
+		}
+	}
+	return registrations, nil
+}
+
+func (r *Remover) deleteRegistrations(g apiGroup, gvr schema.GroupVersionResource, report *Report) error {
+	var registrations []unstructured.Unstructured
+	err := report.track(gvr.Resource, "", "*", actionList, func() (err error) {
+		registrations, err = listRegistrations(r.dynamicClient, gvr, g.Name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, obj := range registrations {
+		name := obj.GetName()
+		err := report.track(obj.GetKind(), "", name, actionDelete, func() error {
+			return r.dynamicClient.Resource(gvr).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// remainingResources lists the objects, APIServices and CRDs of the group
+// still present, those already being deleted apart.
+func remainingResources(dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, g apiGroup) (remaining, deleting []unstructured.Unstructured, err error) {
+	check := func(obj unstructured.Unstructured) {
+		if obj.GetDeletionTimestamp() != nil {
+			deleting = append(deleting, obj)
+			return
+		}
+		remaining = append(remaining, obj)
+	}
+
+	resources, err := discoverResources(discoveryClient, g)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, gvr := range resources {
+		list, err := dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		for _, obj := range list.Items {
+			check(obj)
+		}
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{apiServiceResource, crdResource} {
+		registrations, err := listRegistrations(dynamicClient, gvr, g.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range registrations {
+			check(obj)
+		}
+	}
+	return remaining, deleting, nil
+}
+
+// describeResource names obj along with its kind.
+func describeResource(obj unstructured.Unstructured) string {
+	return fmt.Sprintf("%s %s", obj.GetKind(), objectName(obj))
+}
+
+// verifyGroupRemoved fails if any object, APIService or CRD of the group is
+// still present, or has reappeared, after removal. Objects that are already
+// being deleted are only reported.
+func verifyGroupRemoved(dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, g apiGroup) error {
+	objects, deleting, err := remainingResources(dynamicClient, discoveryClient, g)
+	if err != nil {
+		return err
+	}
+	for _, obj := range deleting {
+		log.Warningf("%s is still being deleted, finalizers: %v", describeResource(obj), obj.GetFinalizers())
+	}
+	var remaining []string
+	for _, obj := range objects {
+		remaining = append(remaining, describeResource(obj))
+	}
+
+	if len(remaining) > 0 {
+		return fmt.Errorf("%s resources are still present after removal: %s", g.Name, strings.Join(remaining, ", "))
+	}
+	return nil
+}
+
+// removeAPIGroup removes every object of the group, stripping the finalizers
+// its departed controllers would have processed, followed by the APIService
+// and CRD registrations of the group.
+func (r *Remover) removeAPIGroup(g apiGroup, report *Report) error {
+	var resources []schema.GroupVersionResource
+	err := report.track("APIGroup", "", g.Name, actionList, func() (err error) {
+		resources, err = discoverResources(r.discoveryClient, g)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("problem discovering %s resources: %v", g.Name, err)
+	}
+
+	var errs []error
+	for _, gvr := range resources {
+		if err := r.deleteGroupResource(g, gvr, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// The registrations go last: without them the objects above could no
+	// longer be reached.
+	for _, gvr := range []schema.GroupVersionResource{apiServiceResource, crdResource} {
+		if err := r.deleteRegistrations(g, gvr, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	return report.track("APIGroup", "", g.Name, actionVerify, func() error {
+		return verifyGroupRemoved(r.dynamicClient, r.discoveryClient, g)
+	})
+}
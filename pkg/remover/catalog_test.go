@@ -0,0 +1,251 @@
+package remover
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRunRemovesAPIGroup(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	clients.serveServiceCatalog(
+		newServiceCatalogObject("ClusterServiceBroker", "", "broker"),
+		newServiceCatalogObject("ClusterServiceClass", "", "class"),
+		newServiceCatalogObject("ServiceBroker", "tenant", "broker"),
+		newRegistration("apiregistration.k8s.io/v1", "APIService", "v1beta1."+serviceCatalogGroup),
+		newRegistration("apiextensions.k8s.io/v1", "CustomResourceDefinition", "clusterservicebrokers."+serviceCatalogGroup),
+		newObject("apiregistration.k8s.io/v1", "APIService", "", "v1.apps"),
+	)
+
+	report := clients.remover(testOptions()).Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Errorf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	if report.Verification == nil || !report.Verification.Passed {
+		t.Errorf("expected the verification to pass, got %+v", report.Verification)
+	}
+
+	// the finalizer is stripped from every object before it is deleted, the
+	// objects in the order of the inventory and the registrations last
+	var actions []string
+	for _, action := range clients.dynamic.Actions() {
+		if action.GetResource() == namespaceResource {
+			continue
+		}
+		switch action.GetVerb() {
+		case "update":
+			obj := action.(clienttesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+			if len(obj.GetFinalizers()) > 0 {
+				t.Errorf("expected the finalizers of %s to be stripped, got %v", obj.GetName(), obj.GetFinalizers())
+			}
+			actions = append(actions, fmt.Sprintf("update %s/%s", action.GetResource().Resource, obj.GetName()))
+		case "delete":
+			actions = append(actions, fmt.Sprintf("delete %s/%s", action.GetResource().Resource, action.(clienttesting.DeleteAction).GetName()))
+		}
+	}
+	expected := []string{
+		"update clusterserviceclasses/class",
+		"delete clusterserviceclasses/class",
+		"update servicebrokers/broker",
+		"delete servicebrokers/broker",
+		"update clusterservicebrokers/broker",
+		"delete clusterservicebrokers/broker",
+		"delete apiservices/v1beta1." + serviceCatalogGroup,
+		"delete customresourcedefinitions/clusterservicebrokers." + serviceCatalogGroup,
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("expected actions %v, got %v", expected, actions)
+	}
+}
+
+func TestRunWhileInUse(t *testing.T) {
+	instance := newServiceCatalogObject("ServiceInstance", "tenant", "database")
+	binding := newServiceCatalogObject("ServiceBinding", "tenant", "database")
+	broker := newServiceCatalogObject("ServiceBroker", "other-tenant", "broker")
+
+	tests := []struct {
+		name            string
+		objects         []*unstructured.Unstructured
+		force           bool
+		acknowledged    bool
+		expectedOutcome ReportOutcome
+		expectedUsage   *CatalogUsage
+		expectedDeleted []string
+	}{
+		{
+			name:            "instances and bindings",
+			objects:         []*unstructured.Unstructured{instance, binding, broker},
+			expectedOutcome: ReportBlocked,
+			expectedUsage: &CatalogUsage{Namespaces: []NamespaceUsage{
+				{Namespace: "other-tenant", ServiceBrokers: 1},
+				{Namespace: "tenant", ServiceInstances: 1, ServiceBindings: 1},
+			}},
+		},
+		{
+			name:            "brokers only",
+			objects:         []*unstructured.Unstructured{broker},
+			expectedOutcome: ReportSucceeded,
+			expectedUsage:   &CatalogUsage{Namespaces: []NamespaceUsage{{Namespace: "other-tenant", ServiceBrokers: 1}}},
+			expectedDeleted: []string{"servicebrokers/broker"},
+		},
+		{
+			name:            "forced",
+			objects:         []*unstructured.Unstructured{instance},
+			force:           true,
+			expectedOutcome: ReportSucceeded,
+			expectedUsage:   &CatalogUsage{Namespaces: []NamespaceUsage{{Namespace: "tenant", ServiceInstances: 1}}},
+			expectedDeleted: []string{"serviceinstances/database"},
+		},
+		{
+			name:            "data loss acknowledged",
+			objects:         []*unstructured.Unstructured{binding},
+			acknowledged:    true,
+			expectedOutcome: ReportSucceeded,
+			expectedUsage:   &CatalogUsage{Namespaces: []NamespaceUsage{{Namespace: "tenant", ServiceBindings: 1}}},
+			expectedDeleted: []string{"servicebindings/database"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			var objects []runtime.Object
+			for _, obj := range test.objects {
+				objects = append(objects, obj.DeepCopy())
+			}
+			clients.serveServiceCatalog(objects...)
+			if test.acknowledged {
+				cr, err := clients.operator.OperatorV1().ServiceCatalogControllerManagers().Get(operatorConfigName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				cr.Annotations = map[string]string{acknowledgeDataLossAnnotation: "true"}
+				if _, err := clients.operator.OperatorV1().ServiceCatalogControllerManagers().Update(cr); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			options := testOptions()
+			options.Force = test.force
+
+			report := clients.remover(options).Run()
+
+			if report.Outcome != test.expectedOutcome {
+				t.Errorf("expected outcome %s, got %s: %s", test.expectedOutcome, report.Outcome, report.Message)
+			}
+			if !reflect.DeepEqual(report.Usage, test.expectedUsage) {
+				t.Errorf("expected usage %+v, got %+v", test.expectedUsage, report.Usage)
+			}
+			expectedDeleted := test.expectedDeleted
+			if expectedDeleted != nil {
+				expectedDeleted = append(append([]string{}, everything...), expectedDeleted...)
+				sort.Strings(expectedDeleted)
+			}
+			if deleted := clients.deletions(); !reflect.DeepEqual(deleted, expectedDeleted) {
+				t.Errorf("expected deletions %v, got %v", expectedDeleted, deleted)
+			}
+		})
+	}
+}
+
+// undiscoverableDiscovery lists the API groups of the discovery it wraps but
+// cannot discover their resources, like a cluster whose aggregated API server
+// is down.
+type undiscoverableDiscovery struct {
+	discovery.DiscoveryInterface
+}
+
+func (d undiscoverableDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	return nil, fmt.Errorf("the server is currently unable to handle the request")
+}
+
+func TestRunWhileUsageUnknown(t *testing.T) {
+	tests := []struct {
+		name            string
+		force           bool
+		expectedOutcome ReportOutcome
+		expectedExit    int
+		expectedDeleted []string
+	}{
+		{
+			name:            "blocked",
+			expectedOutcome: ReportBlocked,
+			expectedExit:    ExitBlocked,
+		},
+		{
+			name:            "forced",
+			force:           true,
+			expectedOutcome: ReportSucceeded,
+			expectedExit:    ExitSucceeded,
+			expectedDeleted: []string{"apiservices/v1beta1." + serviceCatalogGroup},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			clients.serveServiceCatalog(
+				newServiceCatalogObject("ServiceInstance", "tenant", "database"),
+				newRegistration("apiregistration.k8s.io/v1", "APIService", "v1beta1."+serviceCatalogGroup),
+			)
+			options := testOptions()
+			options.Force = test.force
+			r := clients.remover(options)
+			r.discoveryClient = undiscoverableDiscovery{r.discoveryClient}
+
+			report := r.Run()
+
+			if report.Outcome != test.expectedOutcome || report.ExitCode() != test.expectedExit {
+				t.Errorf("expected outcome %s exiting with %d, got %s exiting with %d: %s", test.expectedOutcome, test.expectedExit, report.Outcome, report.ExitCode(), report.Message)
+			}
+			if report.Usage == nil || !strings.Contains(report.Usage.Unknown, "cannot be discovered") {
+				t.Errorf("expected the usage to be unknown, got %+v", report.Usage)
+			}
+			expectedDeleted := test.expectedDeleted
+			if expectedDeleted != nil {
+				expectedDeleted = append(append([]string{}, everything...), expectedDeleted...)
+				sort.Strings(expectedDeleted)
+			}
+			if deleted := clients.deletions(); !reflect.DeepEqual(deleted, expectedDeleted) {
+				t.Errorf("expected deletions %v, got %v", expectedDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestDiscoverResourcesNeedsListAndDelete(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	clients.kube.Resources = append(clients.kube.Resources, &metav1.APIResourceList{
+		GroupVersion: serviceCatalogGroup + "/v1beta1",
+		APIResources: []metav1.APIResource{
+			{Name: "clusterservicebrokers", Kind: "ClusterServiceBroker", Verbs: metav1.Verbs{"get", "list", "delete"}},
+			{Name: "clusterserviceclasses", Kind: "ClusterServiceClass", Verbs: metav1.Verbs{"get", "list", "deletecollection"}},
+			{Name: "serviceinstances", Namespaced: true, Kind: "ServiceInstance", Verbs: metav1.Verbs{"get", "delete"}},
+			{Name: "servicebindings", Namespaced: true, Kind: "ServiceBinding", Verbs: metav1.Verbs{"list", "delete", "deletecollection"}},
+		},
+	})
+	g := apiGroup{Name: serviceCatalogGroup, ResourceOrder: []string{"servicebindings", "clusterservicebrokers"}}
+
+	resources, err := discoverResources(clients.kube.Discovery(), g)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, resource := range resources {
+		names = append(names, resource.Resource)
+	}
+	expected := []string{"servicebindings", "clusterservicebrokers"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the resources that can be listed and deleted %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,68 @@
+package remover
+
+import (
+	configapiv1 "github.com/openshift/api/config/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// clusterOperatorConditionTypes maps the removal conditions to the standard
+// ClusterOperator conditions they are mirrored to with
+// Options.KeepClusterOperator.
+var clusterOperatorConditionTypes = map[string]configapiv1.ClusterStatusConditionType{
+	conditionRemovalProgressing: configapiv1.OperatorProgressing,
+	conditionRemovalDegraded:    configapiv1.OperatorDegraded,
+}
+
+// relatedObjects lists the objects removed along with the component, for
+// `oc adm inspect` and the console to find them from its ClusterOperator.
+func relatedObjects(c component) []configapiv1.ObjectReference {
+	return []configapiv1.ObjectReference{
+		{Group: c.crResource.Group, Resource: c.crResource.Resource, Name: c.crName},
+		{Resource: namespaceResource.Resource, Name: c.operatorNamespace},
+		{Resource: namespaceResource.Resource, Name: c.operandNamespace},
+		{Group: clusterRoleBindingResource.Group, Resource: clusterRoleBindingResource.Resource, Name: c.rbacName},
+		{Group: clusterRoleResource.Group, Resource: clusterRoleResource.Resource, Name: c.rbacName},
+		{Resource: namespaceResource.Resource, Name: RemovedNamespaceName},
+	}
+}
+
+// setRelatedObjects points the ClusterOperator of every component at the
+// objects being removed. A missing ClusterOperator is left alone.
+func (r *Remover) setRelatedObjects() {
+	for _, c := range r.components {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var co *configapiv1.ClusterOperator
+			err := r.retryOnTransientError(func() (err error) {
+				co, err = r.configClient.ClusterOperators().Get(c.clusterOperatorName, metav1.GetOptions{})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			co.Status.RelatedObjects = relatedObjects(c)
+			return r.retryOnTransientError(func() error {
+				_, err := r.configClient.ClusterOperators().UpdateStatus(co)
+				return err
+			})
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Warningf("problem setting the related objects of ClusterOperator %s: %v", c.clusterOperatorName, err)
+		}
+	}
+}
+
+// deleteClusterOperatorsLast deletes the ClusterOperators once everything else
+// was removed. Otherwise they are kept, reporting the failure.
+func (r *Remover) deleteClusterOperatorsLast(report *Report, status *removalStatus, clusterOperators []inventoryObject) {
+	if failed := report.failedSteps(); len(failed) > 0 {
+		log.Warningf("%d steps failed, keeping the ClusterOperators to report it", len(failed))
+		return
+	}
+	status.progress(phaseRemovingClusterOperators, "Everything else was removed, removing the ClusterOperators")
+	if err := r.deleteObjects(report, clusterOperators, false); err != nil {
+		log.Errorf("problem removing the ClusterOperators: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package remover
+
+import (
+	"fmt"
+	"strings"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// component describes one half of Service Catalog: the resources of its
+// operand and of the operator that managed it.
+type component struct {
+	crKind     string
+	crResource schema.GroupVersionResource
+	// crName is the name of the operator CR.
+	crName            string
+	operatorNamespace string
+	operandNamespace  string
+	// clusterOperatorName is the name of the ClusterOperator the operator
+	// reported its status through.
+	clusterOperatorName string
+	// rbacName is the name of both the ClusterRole and the ClusterRoleBinding
+	// of the operator.
+	rbacName string
+}
+
+var controllerManager = component{
+	crKind:              "ServiceCatalogControllerManager",
+	crResource:          schema.GroupVersionResource{Group: "operator.openshift.io", Version: "v1", Resource: "servicecatalogcontrollermanagers"},
+	crName:              operatorConfigName,
+	operatorNamespace:   "openshift-service-catalog-controller-manager-operator",
+	operandNamespace:    "openshift-service-catalog-controller-manager",
+	clusterOperatorName: "service-catalog-controller-manager",
+	rbacName:            "openshift-service-catalog-controller-manager-operator",
+}
+
+var apiServer = component{
+	crKind:              "ServiceCatalogAPIServer",
+	crResource:          schema.GroupVersionResource{Group: "operator.openshift.io", Version: "v1", Resource: "servicecatalogapiservers"},
+	crName:              operatorConfigName,
+	operatorNamespace:   "openshift-service-catalog-apiserver-operator",
+	operandNamespace:    "openshift-service-catalog-apiserver",
+	clusterOperatorName: "service-catalog-apiserver",
+	rbacName:            "openshift-service-catalog-apiserver-operator",
+}
+
+// withNames returns the component with its names replaced by the non-empty
+// ones given.
+func (c component) withNames(names componentNames) component {
+	if len(names.CRName) > 0 {
+		c.crName = names.CRName
+	}
+	if len(names.OperatorNamespace) > 0 {
+		c.operatorNamespace = names.OperatorNamespace
+	}
+	if len(names.OperandNamespace) > 0 {
+		c.operandNamespace = names.OperandNamespace
+	}
+	if len(names.ClusterOperatorName) > 0 {
+		c.clusterOperatorName = names.ClusterOperatorName
+	}
+	if len(names.RBACName) > 0 {
+		c.rbacName = names.RBACName
+	}
+	return c
+}
+
+// ManagementStateMissing is the state ManagementStates holds for an operator
+// CR that does not exist. An existing CR without a managementState holds an
+// empty state, which is not understood.
+const ManagementStateMissing operatorapiv1.ManagementState = "Missing"
+
+// ManagementStates holds the managementState of the operator CR of both
+// halves of Service Catalog, ManagementStateMissing when the CR does not
+// exist.
+type ManagementStates struct {
+	ControllerManager operatorapiv1.ManagementState `json:"controllerManager"`
+	APIServer         operatorapiv1.ManagementState `json:"apiServer"`
+}
+
+// getManagementStates reads the managementState of both operator CRs.
+func (r *Remover) getManagementStates() (ManagementStates, error) {
+	states := ManagementStates{ControllerManager: ManagementStateMissing, APIServer: ManagementStateMissing}
+
+	var controllerManagerConfig *operatorapiv1.ServiceCatalogControllerManager
+	err := r.retryOnTransientError(func() (err error) {
+		controllerManagerConfig, err = r.operatorClient.ServiceCatalogControllerManagers().Get(r.controllerManager.crName, metav1.GetOptions{})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return states, fmt.Errorf("problem getting %s CR (%s): %v", r.controllerManager.crKind, classifyError(err), err)
+	default:
+		states.ControllerManager = controllerManagerConfig.Spec.ManagementState
+	}
+
+	var apiServerConfig *operatorapiv1.ServiceCatalogAPIServer
+	err = r.retryOnTransientError(func() (err error) {
+		apiServerConfig, err = r.operatorClient.ServiceCatalogAPIServers().Get(r.apiServer.crName, metav1.GetOptions{})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return states, fmt.Errorf("problem getting %s CR (%s): %v", r.apiServer.crKind, classifyError(err), err)
+	default:
+		states.APIServer = apiServerConfig.Spec.ManagementState
+	}
+	return states, nil
+}
+
+func describeManagementState(state operatorapiv1.ManagementState) string {
+	if state == ManagementStateMissing {
+		return "removed"
+	}
+	return fmt.Sprintf("'%s'", state)
+}
+
+// removalDecision is what the remover does given ManagementStates.
+type removalDecision string
+
+const (
+	decisionRemove removalDecision = "Remove"
+	// decisionAbort leaves everything in place because Service Catalog is
+	// still Managed.
+	decisionAbort removalDecision = "Abort"
+	// decisionFail leaves everything in place because a managementState is
+	// not understood.
+	decisionFail removalDecision = "Fail"
+)
+
+// decideRemoval applies a single policy to both halves of Service Catalog: an
+// API server without its controller manager, or the other way around, is of
+// no use, so either both are removed or neither is. It returns the decision
+// and its reason.
+func decideRemoval(states ManagementStates) (removalDecision, string) {
+	var managed []string
+	for _, cr := range []struct {
+		kind  string
+		state operatorapiv1.ManagementState
+	}{
+		{controllerManager.crKind, states.ControllerManager},
+		{apiServer.crKind, states.APIServer},
+	} {
+		switch cr.state {
+		case ManagementStateMissing, operatorapiv1.Unmanaged, operatorapiv1.Removed:
+		case operatorapiv1.Managed:
+			managed = append(managed, cr.kind)
+		default:
+			return decisionFail, fmt.Sprintf("Unknown %s managementState '%s'", cr.kind, cr.state)
+		}
+	}
+	if len(managed) > 0 {
+		return decisionAbort, fmt.Sprintf("%s managementState is 'Managed'", strings.Join(managed, " and "))
+	}
+	return decisionRemove, fmt.Sprintf("%s managementState is %s, %s managementState is %s",
+		controllerManager.crKind, describeManagementState(states.ControllerManager),
+		apiServer.crKind, describeManagementState(states.APIServer))
+}
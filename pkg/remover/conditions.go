@@ -0,0 +1,196 @@
+package remover
+
+import (
+	"fmt"
+
+	configapiv1 "github.com/openshift/api/config/v1"
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Conditions the remover sets on the operator CRs and ClusterOperators of
+// Service Catalog while removing them.
+const (
+	conditionRemovalProgressing = "RemovalProgressing"
+	conditionRemovalDegraded    = "RemovalDegraded"
+)
+
+// removalPhase is the reason of the RemovalProgressing condition while the
+// removal goes on. Besides these, the phases of the removal proper are those
+// of the inventory.
+type removalPhase string
+
+const (
+	phaseBackingUp                removalPhase = "BackingUp"
+	phaseRemovingClusterOperators removalPhase = "RemovingClusterOperators"
+	phaseVerifying                removalPhase = "Verifying"
+)
+
+// removalStatus publishes the progress of a removal as conditions on the
+// operator CRs and ClusterOperators of Service Catalog, for as long as they
+// exist. A nil removalStatus publishes nothing.
+type removalStatus struct {
+	remover *Remover
+	report  *Report
+}
+
+func newRemovalStatus(remover *Remover, report *Report) *removalStatus {
+	return &removalStatus{remover: remover, report: report}
+}
+
+// setOperatorCondition sets the condition in conditions, keeping its last
+// transition time when its status does not change.
+func setOperatorCondition(conditions *[]operatorapiv1.OperatorCondition, condition operatorapiv1.OperatorCondition) {
+	condition.LastTransitionTime = metav1.Now()
+	for i := range *conditions {
+		existing := &(*conditions)[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+	*conditions = append(*conditions, condition)
+}
+
+// updateCRConditions sets the conditions on the status of both operator CRs.
+// A missing CR is left alone.
+func (s *removalStatus) updateCRConditions(conditions ...operatorapiv1.OperatorCondition) {
+	operatorClient := s.remover.operatorClient
+	var controllerManagerConfig *operatorapiv1.ServiceCatalogControllerManager
+	s.updateCRStatus(s.remover.controllerManager,
+		func() (status *operatorapiv1.OperatorStatus, err error) {
+			controllerManagerConfig, err = operatorClient.ServiceCatalogControllerManagers().Get(s.remover.controllerManager.crName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return &controllerManagerConfig.Status.OperatorStatus, nil
+		},
+		func() error {
+			_, err := operatorClient.ServiceCatalogControllerManagers().UpdateStatus(controllerManagerConfig)
+			return err
+		},
+		conditions...)
+
+	var apiServerConfig *operatorapiv1.ServiceCatalogAPIServer
+	s.updateCRStatus(s.remover.apiServer,
+		func() (status *operatorapiv1.OperatorStatus, err error) {
+			apiServerConfig, err = operatorClient.ServiceCatalogAPIServers().Get(s.remover.apiServer.crName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return &apiServerConfig.Status.OperatorStatus, nil
+		},
+		func() error {
+			_, err := operatorClient.ServiceCatalogAPIServers().UpdateStatus(apiServerConfig)
+			return err
+		},
+		conditions...)
+}
+
+// updateCRStatus sets the conditions on the status of the operator CR of the
+// component, which get fetches and update writes back, retrying on conflicts.
+// A missing CR is left alone.
+func (s *removalStatus) updateCRStatus(c component, get func() (*operatorapiv1.OperatorStatus, error), update func() error, conditions ...operatorapiv1.OperatorCondition) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var status *operatorapiv1.OperatorStatus
+		err := s.remover.retryOnTransientError(func() (err error) {
+			status, err = get()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, condition := range conditions {
+			setOperatorCondition(&status.Conditions, condition)
+		}
+		return s.remover.retryOnTransientError(update)
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Warningf("problem updating the status of the %s CR: %v", c.crKind, err)
+	}
+}
+
+// update sets the conditions on both operator CRs, if they still exist, and
+// both ClusterOperators.
+func (s *removalStatus) update(conditions ...operatorapiv1.OperatorCondition) {
+	if s == nil {
+		return
+	}
+	s.updateCRConditions(conditions...)
+
+	var coConditions []configapiv1.ClusterOperatorStatusCondition
+	for _, condition := range conditions {
+		coCondition := configapiv1.ClusterOperatorStatusCondition{
+			Type:    configapiv1.ClusterStatusConditionType(condition.Type),
+			Status:  configapiv1.ConditionStatus(condition.Status),
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		}
+		coConditions = append(coConditions, coCondition)
+		if conditionType, ok := clusterOperatorConditionTypes[condition.Type]; ok && s.remover.options.KeepClusterOperator {
+			coCondition.Type = conditionType
+			coConditions = append(coConditions, coCondition)
+		}
+	}
+	for _, c := range s.remover.components {
+		for _, condition := range coConditions {
+			err := s.remover.setClusterOperatorCondition(c.clusterOperatorName, condition)
+			if err != nil {
+				log.Warningf("problem setting the %s condition of ClusterOperator %s: %v", condition.Type, c.clusterOperatorName, err)
+			}
+		}
+	}
+}
+
+// degradedCondition derives RemovalDegraded from the steps that failed so far.
+func (s *removalStatus) degradedCondition() operatorapiv1.OperatorCondition {
+	failed := s.report.failedSteps()
+	if len(failed) == 0 {
+		return operatorapiv1.OperatorCondition{Type: conditionRemovalDegraded, Status: operatorapiv1.ConditionFalse, Reason: "AsExpected"}
+	}
+	last := failed[len(failed)-1]
+	return operatorapiv1.OperatorCondition{
+		Type:    conditionRemovalDegraded,
+		Status:  operatorapiv1.ConditionTrue,
+		Reason:  "StepsFailed",
+		Message: fmt.Sprintf("%d steps failed so far, the last one: %s %s: %s", len(failed), last.Action, describeObject(last.Kind, last.Namespace, last.Name), last.Error),
+	}
+}
+
+// progress records that the removal entered the phase.
+func (s *removalStatus) progress(phase removalPhase, message string) {
+	if s == nil {
+		return
+	}
+	s.report.setPhase(phase)
+	log.WithField("phase", phase).Infof("%s: %s", phase, message)
+	s.update(
+		operatorapiv1.OperatorCondition{Type: conditionRemovalProgressing, Status: operatorapiv1.ConditionTrue, Reason: string(phase), Message: message},
+		s.degradedCondition(),
+	)
+}
+
+// finish records the outcome of a removal that left the operator CRs or
+// ClusterOperators in place, because it stopped early or failed to remove
+// them.
+func (s *removalStatus) finish(outcome ReportOutcome, message string) {
+	if s == nil || outcome == ReportSucceeded {
+		return
+	}
+	degraded := s.degradedCondition()
+	switch outcome {
+	case ReportFailed, ReportBlocked:
+		degraded = operatorapiv1.OperatorCondition{Type: conditionRemovalDegraded, Status: operatorapiv1.ConditionTrue, Reason: string(outcome), Message: message}
+	}
+	s.update(
+		operatorapiv1.OperatorCondition{Type: conditionRemovalProgressing, Status: operatorapiv1.ConditionFalse, Reason: string(outcome), Message: message},
+		degraded,
+	)
+}
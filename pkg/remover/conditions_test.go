@@ -0,0 +1,32 @@
+package remover
+
+import (
+	"testing"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdateCRConditions(t *testing.T) {
+	// the ServiceCatalogAPIServer CR is missing
+	clients := newFakeClients(operatorapiv1.Removed, ManagementStateMissing)
+	r := clients.remover(testOptions())
+	status := newRemovalStatus(r, r.newReport())
+
+	status.updateCRConditions(operatorapiv1.OperatorCondition{Type: conditionRemovalProgressing, Status: operatorapiv1.ConditionTrue, Reason: string(phaseBackingUp)})
+	status.updateCRConditions(operatorapiv1.OperatorCondition{Type: conditionRemovalProgressing, Status: operatorapiv1.ConditionTrue, Reason: string(phaseVerifying)})
+
+	config, err := clients.operator.OperatorV1().ServiceCatalogControllerManagers().Get(operatorConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conditions := config.Status.Conditions
+	if len(conditions) != 1 || conditions[0].Type != conditionRemovalProgressing || conditions[0].Reason != string(phaseVerifying) {
+		t.Errorf("expected the %s condition to be updated to %s, got %+v", conditionRemovalProgressing, phaseVerifying, conditions)
+	}
+	for _, action := range clients.operator.Actions() {
+		if action.GetResource().Resource == "servicecatalogapiservers" && action.GetVerb() != "get" {
+			t.Errorf("expected the missing %s CR to be left alone, got %s", apiServer.crKind, action.GetVerb())
+		}
+	}
+}
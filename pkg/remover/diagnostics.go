@@ -0,0 +1,161 @@
+package remover
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// namespaceDeletionConditions are the NamespaceStatus conditions the
+// namespace controller sets when it cannot finish deleting a namespace.
+var namespaceDeletionConditions = []corev1.NamespaceConditionType{
+	corev1.NamespaceDeletionDiscoveryFailure,
+	corev1.NamespaceDeletionGVParsingFailure,
+	corev1.NamespaceDeletionContentFailure,
+	corev1.NamespaceContentRemaining,
+	corev1.NamespaceFinalizersRemaining,
+}
+
+// RemainingObject is an object still present in a terminating namespace.
+type RemainingObject struct {
+	Group             string       `json:"group,omitempty"`
+	Version           string       `json:"version"`
+	Resource          string       `json:"resource"`
+	Kind              string       `json:"kind"`
+	Name              string       `json:"name"`
+	Finalizers        []string     `json:"finalizers,omitempty"`
+	DeletionTimestamp *metav1.Time `json:"deletionTimestamp,omitempty"`
+}
+
+// NamespaceDiagnostics explains what is holding up the termination of a
+// namespace.
+type NamespaceDiagnostics struct {
+	Namespace string                `json:"namespace"`
+	Phase     corev1.NamespacePhase `json:"phase"`
+	// SpecFinalizers are the finalizers of the namespace itself, such as
+	// kubernetes, which are only removed once the namespace is empty.
+	SpecFinalizers []corev1.FinalizerName `json:"specFinalizers,omitempty"`
+	// Conditions are the deletion conditions of the namespace that are True.
+	Conditions       []corev1.NamespaceCondition `json:"conditions,omitempty"`
+	RemainingObjects []RemainingObject           `json:"remainingObjects,omitempty"`
+	// DiscoveryErrors lists the API groups that could not be searched for
+	// remaining objects.
+	DiscoveryErrors []string `json:"discoveryErrors,omitempty"`
+}
+
+// diagnoseNamespace reads the deletion conditions of the namespace and
+// enumerates, through discovery, every object left in it along with its
+// finalizers.
+func (r *Remover) diagnoseNamespace(namespace string) (*NamespaceDiagnostics, error) {
+	var ns *corev1.Namespace
+	err := r.retryOnTransientError(func() (err error) {
+		ns, err = r.kubeClient.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := &NamespaceDiagnostics{
+		Namespace:      namespace,
+		Phase:          ns.Status.Phase,
+		SpecFinalizers: ns.Spec.Finalizers,
+	}
+	for _, conditionType := range namespaceDeletionConditions {
+		for _, condition := range ns.Status.Conditions {
+			if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+				diagnostics.Conditions = append(diagnostics.Conditions, condition)
+			}
+		}
+	}
+
+	objects, discoveryErrors, err := r.listNamespaceObjects(namespace)
+	if err != nil {
+		return nil, err
+	}
+	diagnostics.DiscoveryErrors = discoveryErrors
+	for _, obj := range objects {
+		diagnostics.RemainingObjects = append(diagnostics.RemainingObjects, RemainingObject{
+			Group:             obj.gvr.Group,
+			Version:           obj.gvr.Version,
+			Resource:          obj.gvr.Resource,
+			Kind:              obj.kind,
+			Name:              obj.object.GetName(),
+			Finalizers:        obj.object.GetFinalizers(),
+			DeletionTimestamp: obj.object.GetDeletionTimestamp(),
+		})
+	}
+	return diagnostics, nil
+}
+
+// namespaceObject is an object found in a namespace through discovery.
+type namespaceObject struct {
+	gvr    schema.GroupVersionResource
+	kind   string
+	object unstructured.Unstructured
+}
+
+// listNamespaceObjects enumerates, through discovery, every object in the
+// namespace. The API groups that could not be discovered or listed are
+// returned alongside the objects that could be found.
+func (r *Remover) listNamespaceObjects(namespace string) ([]namespaceObject, []string, error) {
+	var failures []string
+	// discovery returns what it could find along with an error naming the
+	// groups it could not
+	resourceLists, err := discovery.ServerPreferredNamespacedResources(r.discoveryClient)
+	if err != nil {
+		groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+		if !ok {
+			return nil, nil, err
+		}
+		for gv, gvErr := range groupErr.Groups {
+			failures = append(failures, fmt.Sprintf("%s: %v", gv, gvErr))
+		}
+	}
+
+	var objects []namespaceObject
+	resourceLists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, resourceLists)
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", resourceList.GroupVersion, err))
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			gvr := gv.WithResource(resource.Name)
+			var list *unstructured.UnstructuredList
+			err := r.retryOnTransientError(func() (err error) {
+				list, err = r.dynamicClient.Resource(gvr).Namespace(namespace).List(metav1.ListOptions{})
+				return err
+			})
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", gvr.GroupResource(), err))
+				continue
+			}
+			for _, obj := range list.Items {
+				objects = append(objects, namespaceObject{gvr: gvr, kind: resource.Kind, object: obj})
+			}
+		}
+	}
+	return objects, failures, nil
+}
+
+// logNamespaceDiagnostics logs what is holding up the termination of the
+// namespace.
+func logNamespaceDiagnostics(diagnostics *NamespaceDiagnostics) {
+	log.Warningf("namespace %s is %s with finalizers %v", diagnostics.Namespace, diagnostics.Phase, diagnostics.SpecFinalizers)
+	for _, condition := range diagnostics.Conditions {
+		log.Warningf("namespace %s condition %s (%s): %s", diagnostics.Namespace, condition.Type, condition.Reason, condition.Message)
+	}
+	for _, obj := range diagnostics.RemainingObjects {
+		log.Warningf("namespace %s still contains %s %s, finalizers: %v", diagnostics.Namespace, obj.Kind, obj.Name, obj.Finalizers)
+	}
+	for _, discoveryErr := range diagnostics.DiscoveryErrors {
+		log.Warningf("namespace %s could not be searched for %s", diagnostics.Namespace, discoveryErr)
+	}
+}
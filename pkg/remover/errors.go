@@ -0,0 +1,54 @@
+package remover
+
+import (
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// ErrorClass tells the remover how to react to an API error.
+type ErrorClass string
+
+const (
+	// ErrorClassNotFound means the object is already gone.
+	ErrorClassNotFound ErrorClass = "NotFound"
+	// ErrorClassRetryable errors are transient: the same call may succeed if
+	// it is retried.
+	ErrorClassRetryable ErrorClass = "Retryable"
+	// ErrorClassForbidden means the remover is not allowed to make the call,
+	// retrying will not help until its RBAC is fixed.
+	ErrorClassForbidden ErrorClass = "Forbidden"
+	// ErrorClassPermanent errors will fail the same way every time.
+	ErrorClassPermanent ErrorClass = "Permanent"
+)
+
+// classifyError returns the class of err, or an empty class for a nil error.
+func classifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ""
+	case apierrors.IsNotFound(err):
+		return ErrorClassNotFound
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return ErrorClassForbidden
+	case apierrors.IsConflict(err),
+		apierrors.IsServerTimeout(err),
+		apierrors.IsTimeout(err),
+		apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err),
+		apierrors.IsInternalError(err),
+		apierrors.IsUnexpectedServerError(err):
+		return ErrorClassRetryable
+	case utilnet.IsConnectionRefused(err), utilnet.IsConnectionReset(err), utilnet.IsProbableEOF(err):
+		return ErrorClassRetryable
+	}
+	if err == errDeadlineExceeded {
+		// the removal may well succeed when the job is retried
+		return ErrorClassRetryable
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrorClassRetryable
+	}
+	return ErrorClassPermanent
+}
@@ -0,0 +1,140 @@
+package remover
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventComponent is the source component of the events of the remover.
+const eventComponent = "openshift-service-catalog-controller-manager-remover"
+
+// eventRecorder records events against the namespace the remover runs in,
+// which is not one of those it removes. Events are created
+// synchronously: the remover exits as soon as it is done, which would drop
+// events still queued by an asynchronous broadcaster. A nil recorder records
+// nothing.
+type eventRecorder struct {
+	kubeClient     kubernetes.Interface
+	retry          *RetryPolicy
+	involvedObject corev1.ObjectReference
+}
+
+func newEventRecorder(kubeClient kubernetes.Interface, retry *RetryPolicy) *eventRecorder {
+	return &eventRecorder{
+		kubeClient: kubeClient,
+		retry:      retry,
+		involvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			// the namespace of an event has to be that of its involved
+			// object, even a cluster-scoped one
+			Namespace: RemovedNamespaceName,
+			Name:      RemovedNamespaceName,
+		},
+	}
+}
+
+// Event records a Normal event.
+func (r *eventRecorder) Event(reason, message string) {
+	r.record(corev1.EventTypeNormal, reason, message)
+}
+
+func (r *eventRecorder) Eventf(reason, messageFmt string, args ...interface{}) {
+	r.Event(reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// Warning records a Warning event.
+func (r *eventRecorder) Warning(reason, message string) {
+	r.record(corev1.EventTypeWarning, reason, message)
+}
+
+func (r *eventRecorder) Warningf(reason, messageFmt string, args ...interface{}) {
+	r.Warning(reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// eventSequence tells apart the names of events recorded at the same time.
+var eventSequence uint32
+
+// record creates the event. Failing to do so is logged but does not affect
+// the removal.
+func (r *eventRecorder) record(eventType, reason, message string) {
+	if r == nil {
+		return
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// phases running in parallel can record events at the same time
+			Name:      fmt.Sprintf("%s.%x%x", r.involvedObject.Name, time.Now().UnixNano(), atomic.AddUint32(&eventSequence, 1)),
+			Namespace: RemovedNamespaceName,
+		},
+		InvolvedObject: r.involvedObject,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := r.retry.do(func() error {
+		_, err := r.kubeClient.CoreV1().Events(RemovedNamespaceName).Create(event)
+		return err
+	})
+	if err != nil {
+		log.Warningf("problem recording %s event %s: %v", eventType, reason, err)
+	}
+}
+
+// recordedKinds are the kinds whose successful removal steps are recorded as
+// events. The removal of each service catalog object is only recorded when it
+// fails, there can be far too many of them.
+var recordedKinds = map[string]bool{
+	"Namespace":                true,
+	controllerManager.crKind:   true,
+	apiServer.crKind:           true,
+	"ClusterOperator":          true,
+	"ClusterRole":              true,
+	"ClusterRoleBinding":       true,
+	"APIService":               true,
+	"CustomResourceDefinition": true,
+	"APIGroup":                 true,
+	"Backup":                   true,
+}
+
+// recordStep records an event for the step: a Warning if it failed, a Normal
+// event if it succeeded and its kind is one of recordedKinds. The reason
+// concatenates the kind, action and outcome, as in NamespaceDeleteSucceeded.
+func (r *eventRecorder) recordStep(step StepResult) {
+	reason := step.Kind + step.Action + string(step.Outcome)
+	object := describeObject(step.Kind, step.Namespace, step.Name)
+	switch step.Outcome {
+	case StepFailed, StepStuck:
+		r.Warningf(reason, "%s %s failed (%s): %s", step.Action, object, step.ErrorClass, step.Error)
+	case StepNotFound:
+		if recordedKinds[step.Kind] {
+			r.Eventf(reason, "%s %s: already removed", step.Action, object)
+		}
+	default:
+		if recordedKinds[step.Kind] {
+			r.Eventf(reason, "%s %s succeeded", step.Action, object)
+		}
+	}
+}
+
+// recordOutcome records the outcome of the whole removal, as in
+// RemovalSucceeded or RemovalAborted.
+func (r *eventRecorder) recordOutcome(outcome ReportOutcome, message string) {
+	reason := "Removal" + string(outcome)
+	if outcome == ReportSucceeded {
+		r.Event(reason, message)
+		return
+	}
+	r.Warning(reason, message)
+}
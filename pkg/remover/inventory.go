@@ -0,0 +1,468 @@
+package remover
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// inventoryConfigMapKey is the key of Options.InventoryConfigMap holding the
+// inventory.
+const inventoryConfigMapKey = "inventory.yaml"
+
+// inventoryPolicy is how an object of the inventory is removed.
+type inventoryPolicy struct {
+	// PropagationPolicy is the propagation policy of the deletion, the
+	// default of the resource when empty.
+	PropagationPolicy metav1.DeletionPropagation `json:"propagationPolicy,omitempty"`
+	// StripFinalizers are removed from the object before it is deleted, for
+	// finalizers whose controller is gone.
+	StripFinalizers []string `json:"stripFinalizers,omitempty"`
+	// SkipVerify leaves the object out of the verification checklist, for
+	// objects something else is expected to recreate.
+	SkipVerify bool `json:"skipVerify,omitempty"`
+}
+
+func (p inventoryPolicy) deleteOptions() *metav1.DeleteOptions {
+	options := &metav1.DeleteOptions{}
+	if len(p.PropagationPolicy) > 0 {
+		propagationPolicy := p.PropagationPolicy
+		options.PropagationPolicy = &propagationPolicy
+	}
+	return options
+}
+
+// inventoryObjectSpec is an object of the inventory, picked by name or, for
+// all the objects it matches, by label selector.
+type inventoryObjectSpec struct {
+	Group         string          `json:"group,omitempty"`
+	Version       string          `json:"version"`
+	Resource      string          `json:"resource"`
+	Kind          string          `json:"kind"`
+	Namespace     string          `json:"namespace,omitempty"`
+	Name          string          `json:"name,omitempty"`
+	LabelSelector string          `json:"labelSelector,omitempty"`
+	Policy        inventoryPolicy `json:"policy,omitempty"`
+}
+
+// inventoryPhaseSpec is a phase of the removal: its objects go in order, then
+// its API groups.
+type inventoryPhaseSpec struct {
+	// Name is the reason of the RemovalProgressing condition during the
+	// phase.
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// DependsOn lists the phases that have to be done before the phase
+	// starts. Left out, the phase depends on the phase listed before it; an
+	// empty list lets it start right away.
+	DependsOn []string              `json:"dependsOn"`
+	Objects   []inventoryObjectSpec `json:"objects,omitempty"`
+	APIGroups []apiGroup            `json:"apiGroups,omitempty"`
+}
+
+// inventorySpec is the inventory as written down in inventory.yaml.
+type inventorySpec struct {
+	Phases []inventoryPhaseSpec `json:"phases"`
+}
+
+// componentNames are the names of a component the inventory refers to.
+type componentNames struct {
+	CRName              string
+	OperatorNamespace   string
+	OperandNamespace    string
+	ClusterOperatorName string
+	RBACName            string
+}
+
+func (c component) names() componentNames {
+	return componentNames{
+		CRName:              c.crName,
+		OperatorNamespace:   c.operatorNamespace,
+		OperandNamespace:    c.operandNamespace,
+		ClusterOperatorName: c.clusterOperatorName,
+		RBACName:            c.rbacName,
+	}
+}
+
+// inventoryNames are what the names and namespaces of the inventory, which
+// are text/template templates, are executed with:
+// {{.ControllerManager.OperandNamespace}} for one.
+type inventoryNames struct {
+	ControllerManager componentNames
+	APIServer         componentNames
+	RemovedNamespace  string
+}
+
+// inventoryObject is an object of the inventory, with its names resolved.
+type inventoryObject struct {
+	gvr       schema.GroupVersionResource
+	kind      string
+	namespace string
+	name      string
+	// labelSelector, set instead of name, stands for every object it
+	// matches, in namespace if set.
+	labelSelector string
+	policy        inventoryPolicy
+}
+
+// inventoryPhase is a phase of the inventory, with its names resolved.
+type inventoryPhase struct {
+	name        removalPhase
+	description string
+	dependsOn   []removalPhase
+	objects     []inventoryObject
+	apiGroups   []apiGroup
+}
+
+// inventory is what the remover removes, phase by phase. The phases make a
+// graph, each depending on the phases that have to be done before it starts;
+// they are kept in an order in which every phase comes after those it depends
+// on, the order Plan and Verify list the objects in. Plan, Run and Verify all
+// work from it. The remover embeds it from inventory.yaml; a ConfigMap named
+// by Options.InventoryConfigMap can replace it, so that leftovers can be added
+// without changing the remover, within what allowed permits.
+type inventory struct {
+	phases []inventoryPhase
+}
+
+// parseInventory executes data as a template with names and parses the
+// result.
+func parseInventory(data string, names inventoryNames) (*inventory, error) {
+	tmpl, err := template.New("inventory").Option("missingkey=error").Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, names); err != nil {
+		return nil, err
+	}
+	var spec inventorySpec
+	if err := yaml.UnmarshalStrict(rendered.Bytes(), &spec); err != nil {
+		return nil, err
+	}
+
+	if len(spec.Phases) == 0 {
+		return nil, fmt.Errorf("no phases")
+	}
+	inv := &inventory{}
+	seen := map[string]bool{}
+	for i, phaseSpec := range spec.Phases {
+		if len(phaseSpec.Name) == 0 {
+			return nil, fmt.Errorf("phase %d has no name", i)
+		}
+		if seen[phaseSpec.Name] {
+			return nil, fmt.Errorf("phase %s is listed twice", phaseSpec.Name)
+		}
+		seen[phaseSpec.Name] = true
+		phase := inventoryPhase{name: removalPhase(phaseSpec.Name), description: phaseSpec.Description, apiGroups: phaseSpec.APIGroups}
+		if len(phase.description) == 0 {
+			phase.description = phaseSpec.Name
+		}
+		switch {
+		case phaseSpec.DependsOn != nil:
+			for _, dependency := range phaseSpec.DependsOn {
+				phase.dependsOn = append(phase.dependsOn, removalPhase(dependency))
+			}
+		case i > 0:
+			phase.dependsOn = []removalPhase{removalPhase(spec.Phases[i-1].Name)}
+		}
+		for j, obj := range phaseSpec.Objects {
+			if len(obj.Version) == 0 || len(obj.Resource) == 0 || len(obj.Kind) == 0 {
+				return nil, fmt.Errorf("object %d of phase %s needs a version, a resource and a kind", j, phaseSpec.Name)
+			}
+			if (len(obj.Name) == 0) == (len(obj.LabelSelector) == 0) {
+				return nil, fmt.Errorf("object %d of phase %s needs either a name or a label selector", j, phaseSpec.Name)
+			}
+			if _, err := labels.Parse(obj.LabelSelector); err != nil {
+				return nil, fmt.Errorf("object %d of phase %s has an invalid label selector: %v", j, phaseSpec.Name, err)
+			}
+			switch obj.Policy.PropagationPolicy {
+			case "", metav1.DeletePropagationOrphan, metav1.DeletePropagationBackground, metav1.DeletePropagationForeground:
+			default:
+				return nil, fmt.Errorf("object %d of phase %s has an unknown propagation policy %q", j, phaseSpec.Name, obj.Policy.PropagationPolicy)
+			}
+			phase.objects = append(phase.objects, inventoryObject{
+				gvr:           schema.GroupVersionResource{Group: obj.Group, Version: obj.Version, Resource: obj.Resource},
+				kind:          obj.Kind,
+				namespace:     obj.Namespace,
+				name:          obj.Name,
+				labelSelector: obj.LabelSelector,
+				policy:        obj.Policy,
+			})
+		}
+		for j, g := range phaseSpec.APIGroups {
+			if len(g.Name) == 0 {
+				return nil, fmt.Errorf("API group %d of phase %s has no name", j, phaseSpec.Name)
+			}
+		}
+		inv.phases = append(inv.phases, phase)
+	}
+	for _, phase := range inv.phases {
+		for _, dependency := range phase.dependsOn {
+			if dependency == phase.name {
+				return nil, fmt.Errorf("phase %s depends on itself", phase.name)
+			}
+			if !seen[string(dependency)] {
+				return nil, fmt.Errorf("phase %s depends on unknown phase %s", phase.name, dependency)
+			}
+		}
+	}
+	if inv.phases, err = sortPhases(inv.phases); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// sortPhases orders the phases so that every phase comes after those it
+// depends on, keeping them in the order they are listed in otherwise. It fails
+// when the dependencies make a cycle.
+func sortPhases(phases []inventoryPhase) ([]inventoryPhase, error) {
+	done := map[removalPhase]bool{}
+	ready := func(phase inventoryPhase) bool {
+		for _, dependency := range phase.dependsOn {
+			if !done[dependency] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var sorted []inventoryPhase
+	for len(sorted) < len(phases) {
+		next := -1
+		for i, phase := range phases {
+			if !done[phase.name] && ready(phase) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for _, phase := range phases {
+				if !done[phase.name] {
+					cycle = append(cycle, string(phase.name))
+				}
+			}
+			return nil, fmt.Errorf("the dependencies of phases %s make a cycle", strings.Join(cycle, ", "))
+		}
+		sorted = append(sorted, phases[next])
+		done[phases[next].name] = true
+	}
+	return sorted, nil
+}
+
+// inventoryData returns the inventory, the one the remover embeds or, when
+// Options.InventoryConfigMap is set, the one that ConfigMap holds, along with
+// where it comes from.
+func (r *Remover) inventoryData() (data, source string, err error) {
+	name := r.options.InventoryConfigMap
+	if len(name) == 0 {
+		return defaultInventory, "the embedded inventory", nil
+	}
+	var cm *corev1.ConfigMap
+	err = r.retryOnTransientError(func() (err error) {
+		cm, err = r.kubeClient.CoreV1().ConfigMaps(RemovedNamespaceName).Get(name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("problem getting ConfigMap %s/%s: %v", RemovedNamespaceName, name, err)
+	}
+	source = fmt.Sprintf("ConfigMap %s/%s", RemovedNamespaceName, name)
+	data, ok := cm.Data[inventoryConfigMapKey]
+	if !ok {
+		return "", "", fmt.Errorf("%s has no %s", source, inventoryConfigMapKey)
+	}
+	return data, source, nil
+}
+
+// inventory returns the inventory of the Service Catalog of the remover, its
+// names resolved with the options.
+func (r *Remover) inventory() (*inventory, error) {
+	data, source, err := r.inventoryData()
+	if err != nil {
+		return nil, err
+	}
+	inv, err := parseInventory(data, inventoryNames{
+		ControllerManager: r.controllerManager.names(),
+		APIServer:         r.apiServer.names(),
+		RemovedNamespace:  RemovedNamespaceName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing the inventory of %s: %v", source, err)
+	}
+	if err := r.allowed(inv); err != nil {
+		return nil, fmt.Errorf("the inventory of %s is not allowed: %v", source, err)
+	}
+	return inv, nil
+}
+
+// allowed checks that the inventory only removes what belongs to Service
+// Catalog: objects in its namespaces, the namespaces themselves, its operator
+// CRs, ClusterOperators and RBAC, and its API group. Cluster-wide objects are
+// checked by name whatever namespace they are given. The remover runs as
+// cluster-admin, and whoever can edit Options.InventoryConfigMap should not be
+// able to have it remove anything else.
+func (r *Remover) allowed(inv *inventory) error {
+	namespaces := map[string]bool{}
+	clusterScoped := map[schema.GroupResource]map[string]bool{
+		namespaceResource.GroupResource():          namespaces,
+		clusterOperatorResource.GroupResource():    {},
+		clusterRoleBindingResource.GroupResource(): {},
+		clusterRoleResource.GroupResource():        {},
+	}
+	for _, c := range []component{r.controllerManager, r.apiServer} {
+		namespaces[c.operatorNamespace] = true
+		namespaces[c.operandNamespace] = true
+		clusterScoped[c.crResource.GroupResource()] = map[string]bool{c.crName: true}
+		clusterScoped[clusterOperatorResource.GroupResource()][c.clusterOperatorName] = true
+		clusterScoped[clusterRoleBindingResource.GroupResource()][c.rbacName] = true
+		clusterScoped[clusterRoleResource.GroupResource()][c.rbacName] = true
+	}
+
+	for _, phase := range inv.phases {
+		for _, obj := range phase.objects {
+			if clusterScopedResources[obj.gvr.GroupResource()] && len(obj.namespace) > 0 {
+				return fmt.Errorf("phase %s removes cluster-wide %s in namespace %s, cluster-wide objects have none", phase.name, obj.gvr.GroupResource(), obj.namespace)
+			}
+			if names, ok := clusterScoped[obj.gvr.GroupResource()]; ok {
+				if !names[obj.name] {
+					return fmt.Errorf("phase %s removes %s, not one of Service Catalog", phase.name, describeObject(obj.kind, "", describeSelected(obj)))
+				}
+				continue
+			}
+			if len(obj.namespace) == 0 {
+				return fmt.Errorf("phase %s removes cluster-wide %s, only namespaces, operator CRs, ClusterOperators and RBAC of Service Catalog can be", phase.name, obj.gvr.GroupResource())
+			}
+			if !namespaces[obj.namespace] {
+				return fmt.Errorf("phase %s removes %s in namespace %s, not one of Service Catalog", phase.name, obj.gvr.GroupResource(), obj.namespace)
+			}
+		}
+		for _, g := range phase.apiGroups {
+			if g.Name != serviceCatalogGroup {
+				return fmt.Errorf("phase %s removes API group %s, only %s can be", phase.name, g.Name, serviceCatalogGroup)
+			}
+		}
+	}
+	return nil
+}
+
+// describeSelected names obj, or the objects its label selector picks.
+func describeSelected(obj inventoryObject) string {
+	if len(obj.labelSelector) > 0 {
+		return fmt.Sprintf("labeled %s", obj.labelSelector)
+	}
+	return obj.name
+}
+
+func isClusterOperator(obj inventoryObject) bool {
+	return obj.gvr.GroupResource() == clusterOperatorResource.GroupResource()
+}
+
+// ordered returns the phases of the inventory. keepClusterOperator takes the
+// ClusterOperators out of them, to be removed last, as
+// Options.KeepClusterOperator does.
+func (i *inventory) ordered(keepClusterOperator bool) (phases []inventoryPhase, clusterOperators []inventoryObject) {
+	if !keepClusterOperator {
+		return i.phases, nil
+	}
+	for _, phase := range i.phases {
+		var objects []inventoryObject
+		for _, obj := range phase.objects {
+			if isClusterOperator(obj) {
+				clusterOperators = append(clusterOperators, obj)
+				continue
+			}
+			objects = append(objects, obj)
+		}
+		phase.objects = objects
+		phases = append(phases, phase)
+	}
+	return phases, clusterOperators
+}
+
+// objects returns every object of the inventory, in removal order.
+func (i *inventory) objects(keepClusterOperator bool) []inventoryObject {
+	phases, clusterOperators := i.ordered(keepClusterOperator)
+	var objects []inventoryObject
+	for _, phase := range phases {
+		objects = append(objects, phase.objects...)
+	}
+	return append(objects, clusterOperators...)
+}
+
+// apiGroups returns every API group of the inventory.
+func (i *inventory) apiGroups() []apiGroup {
+	var groups []apiGroup
+	for _, phase := range i.phases {
+		groups = append(groups, phase.apiGroups...)
+	}
+	return groups
+}
+
+// selectObjects returns the objects the label selector of obj matches. None
+// match when the resource is not served.
+func (r *Remover) selectObjects(obj inventoryObject) ([]inventoryObject, error) {
+	list, err := resourceClient(r.dynamicClient, obj.gvr, obj.namespace).List(metav1.ListOptions{LabelSelector: obj.labelSelector})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var selected []inventoryObject
+	for _, item := range list.Items {
+		s := obj
+		s.namespace = item.GetNamespace()
+		s.name = item.GetName()
+		s.labelSelector = ""
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// resolve returns the objects, with those picked by label selector replaced
+// by the objects they match.
+func (r *Remover) resolve(objects []inventoryObject) ([]inventoryObject, error) {
+	var resolved []inventoryObject
+	for _, obj := range objects {
+		if len(obj.labelSelector) == 0 {
+			resolved = append(resolved, obj)
+			continue
+		}
+		var selected []inventoryObject
+		err := r.retryOnTransientError(func() (err error) {
+			selected, err = r.selectObjects(obj)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("problem listing %s matching %s: %v", obj.gvr.Resource, obj.labelSelector, err)
+		}
+		resolved = append(resolved, selected...)
+	}
+	return resolved, nil
+}
+
+// get returns the object, nil when it is gone.
+func (r *Remover) get(obj inventoryObject) (*unstructured.Unstructured, error) {
+	var found *unstructured.Unstructured
+	err := r.retryOnTransientError(func() (err error) {
+		found, err = resourceClient(r.dynamicClient, obj.gvr, obj.namespace).Get(obj.name, metav1.GetOptions{})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("problem getting %s: %v", describeObject(obj.kind, obj.namespace, obj.name), err)
+	}
+	return found, nil
+}
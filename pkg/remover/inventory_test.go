@@ -0,0 +1,386 @@
+package remover
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDefaultInventory(t *testing.T) {
+	r := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed).remover(testOptions())
+	inv, err := r.inventory()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	describe := func(objects []inventoryObject) []string {
+		var described []string
+		for _, obj := range objects {
+			described = append(described, describeObject(obj.kind, obj.namespace, obj.name))
+		}
+		return described
+	}
+	expected := []string{
+		"Namespace " + controllerManager.operatorNamespace,
+		"Namespace " + apiServer.operatorNamespace,
+		"Namespace " + controllerManager.operandNamespace,
+		"Namespace " + apiServer.operandNamespace,
+		controllerManager.crKind + " " + operatorConfigName,
+		apiServer.crKind + " " + operatorConfigName,
+		"ClusterOperator " + controllerManager.clusterOperatorName,
+		"ClusterOperator " + apiServer.clusterOperatorName,
+		"ClusterRoleBinding " + controllerManager.rbacName,
+		"ClusterRole " + controllerManager.rbacName,
+		"ClusterRoleBinding " + apiServer.rbacName,
+		"ClusterRole " + apiServer.rbacName,
+	}
+	if objects := describe(inv.objects(false)); !reflect.DeepEqual(objects, expected) {
+		t.Errorf("expected objects %v, got %v", expected, objects)
+	}
+	keepClusterOperator := append(append(append([]string{}, expected[:6]...), expected[8:]...), expected[6:8]...)
+	if objects := describe(inv.objects(true)); !reflect.DeepEqual(objects, keepClusterOperator) {
+		t.Errorf("expected objects %v with the ClusterOperators kept, got %v", keepClusterOperator, objects)
+	}
+
+	groups := inv.apiGroups()
+	if len(groups) != 1 || groups[0].Name != serviceCatalogGroup || groups[0].ResourceOrder[0] != "servicebindings" || groups[0].StripFinalizers[0] != "kubernetes-incubator/service-catalog" {
+		t.Errorf("expected the %s API group, got %+v", serviceCatalogGroup, groups)
+	}
+}
+
+func TestParseInventory(t *testing.T) {
+	names := inventoryNames{ControllerManager: controllerManager.names(), APIServer: apiServer.names(), RemovedNamespace: RemovedNamespaceName}
+
+	tests := []struct {
+		name          string
+		data          string
+		expectedError string
+	}{
+		{
+			name: "valid",
+			data: `
+phases:
+- name: RemovingLeftovers
+  objects:
+  - version: v1
+    resource: configmaps
+    kind: ConfigMap
+    namespace: "{{.RemovedNamespace}}"
+    labelSelector: app=leftover
+    policy:
+      propagationPolicy: Foreground
+      stripFinalizers: [example.com/leftover]
+`,
+		},
+		{
+			name:          "no phases",
+			data:          "phases: []",
+			expectedError: "no phases",
+		},
+		{
+			name:          "unknown field",
+			data:          "phases:\n- name: Removing\n  bogus: true",
+			expectedError: "unknown field",
+		},
+		{
+			name:          "unknown name",
+			data:          "phases:\n- name: \"{{.Bogus}}\"",
+			expectedError: "Bogus",
+		},
+		{
+			name:          "phase listed twice",
+			data:          "phases:\n- name: Removing\n- name: Removing",
+			expectedError: "listed twice",
+		},
+		{
+			name:          "neither name nor label selector",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: configmaps, kind: ConfigMap}",
+			expectedError: "either a name or a label selector",
+		},
+		{
+			name:          "invalid label selector",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: configmaps, kind: ConfigMap, labelSelector: \"a b\"}",
+			expectedError: "invalid label selector",
+		},
+		{
+			name:          "unknown propagation policy",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: configmaps, kind: ConfigMap, name: a, policy: {propagationPolicy: Bogus}}",
+			expectedError: "unknown propagation policy",
+		},
+		{
+			name:          "unknown dependency",
+			data:          "phases:\n- name: Removing\n  dependsOn: [Bogus]",
+			expectedError: "depends on unknown phase Bogus",
+		},
+		{
+			name:          "dependency on itself",
+			data:          "phases:\n- name: Removing\n  dependsOn: [Removing]",
+			expectedError: "depends on itself",
+		},
+		{
+			name:          "dependency cycle",
+			data:          "phases:\n- name: First\n  dependsOn: [Second]\n- name: Second",
+			expectedError: "make a cycle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseInventory(test.data, names)
+			switch {
+			case len(test.expectedError) == 0 && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case len(test.expectedError) > 0 && err == nil:
+				t.Errorf("expected an error containing %q", test.expectedError)
+			case err != nil && !strings.Contains(err.Error(), test.expectedError):
+				t.Errorf("expected an error containing %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestSortPhases(t *testing.T) {
+	names := inventoryNames{ControllerManager: controllerManager.names(), APIServer: apiServer.names(), RemovedNamespace: RemovedNamespaceName}
+	inv, err := parseInventory(`
+phases:
+- name: Last
+  dependsOn: [Second]
+- name: First
+  dependsOn: []
+- name: Second
+- name: Independent
+  dependsOn: []
+`, names)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sorted []removalPhase
+	for _, phase := range inv.phases {
+		sorted = append(sorted, phase.name)
+	}
+	// Second depends on First, listed before it
+	expected := []removalPhase{"First", "Second", "Last", "Independent"}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("expected phases %v, got %v", expected, sorted)
+	}
+}
+
+func TestRunFollowsPhaseDependencies(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	r := clients.remover(testOptions())
+	inv, err := r.inventory()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report := r.Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Errorf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	// the steps are recorded as they end: every step of a phase comes after
+	// every step of the phases it depends on
+	last := map[string]int{}
+	waited := map[string]bool{}
+	for i, step := range report.Steps {
+		last[step.Phase] = i
+		if step.Action == actionWaitForDeletion {
+			waited[describeObject(step.Kind, step.Namespace, step.Name)] = true
+		}
+	}
+	// without --wait only the namespaces of phases others depend on, all of
+	// them by default, are waited for
+	expectedWaited := map[string]bool{
+		"Namespace " + controllerManager.operatorNamespace: true,
+		"Namespace " + controllerManager.operandNamespace:  true,
+		"Namespace " + apiServer.operatorNamespace:         true,
+		"Namespace " + apiServer.operandNamespace:          true,
+	}
+	if !reflect.DeepEqual(waited, expectedWaited) {
+		t.Errorf("expected to wait for %v, waited for %v", expectedWaited, waited)
+	}
+	for i, step := range report.Steps {
+		for _, phase := range inv.phases {
+			if string(phase.name) != step.Phase {
+				continue
+			}
+			for _, dependency := range phase.dependsOn {
+				if j, ok := last[string(dependency)]; ok && j > i {
+					t.Errorf("step %d, %s %s of phase %s, ran before step %d of phase %s it depends on", i, step.Action, describeObject(step.Kind, step.Namespace, step.Name), step.Phase, j, dependency)
+				}
+			}
+		}
+	}
+}
+
+func TestRunWithInventoryConfigMap(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	inventoryConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: RemovedNamespaceName, Name: "other-inventory"},
+		Data: map[string]string{inventoryConfigMapKey: `
+phases:
+- name: RemovingLeftovers
+  description: Removing the leftovers
+  objects:
+  - version: v1
+    resource: namespaces
+    kind: Namespace
+    name: "{{.ControllerManager.OperandNamespace}}"
+  - version: v1
+    resource: configmaps
+    kind: ConfigMap
+    namespace: "{{.ControllerManager.OperatorNamespace}}"
+    labelSelector: app=leftover
+`},
+	}
+	if _, err := clients.kube.CoreV1().ConfigMaps(RemovedNamespaceName).Create(inventoryConfigMap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configMap := func(name string, labels map[string]string) *unstructured.Unstructured {
+		obj := newObject("v1", "ConfigMap", controllerManager.operatorNamespace, name)
+		obj.SetLabels(labels)
+		return obj
+	}
+	clients.dynamic = newFakeDynamicClient(
+		configMap("leftover", map[string]string{"app": "leftover"}),
+		configMap("unrelated", map[string]string{"app": "unrelated"}),
+	)
+	options := testOptions()
+	options.InventoryConfigMap = inventoryConfigMap.Name
+
+	report := clients.remover(options).Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Errorf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	expected := []string{
+		"configmaps/leftover",
+		"namespaces/" + controllerManager.operandNamespace,
+	}
+	if deleted := clients.deletions(); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected deletions %v, got %v", expected, deleted)
+	}
+	if report.Verification == nil || !report.Verification.Passed {
+		t.Errorf("expected the verification to pass, got %+v", report.Verification)
+	}
+}
+
+func TestRunWithDisallowedInventoryConfigMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		expectedError string
+	}{
+		{
+			name:          "namespace of another component",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: namespaces, kind: Namespace, name: openshift-etcd}",
+			expectedError: "Namespace openshift-etcd, not one of Service Catalog",
+		},
+		{
+			name:          "object in another namespace",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: secrets, kind: Secret, namespace: kube-system, labelSelector: app=leftover}",
+			expectedError: "secrets in namespace kube-system",
+		},
+		{
+			name:          "cluster-wide resource",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {group: apiextensions.k8s.io, version: v1, resource: customresourcedefinitions, kind: CustomResourceDefinition, name: clusterversions.config.openshift.io}",
+			expectedError: "cluster-wide customresourcedefinitions.apiextensions.k8s.io",
+		},
+		{
+			name:          "ClusterRoles by label selector",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {group: rbac.authorization.k8s.io, version: v1, resource: clusterroles, kind: ClusterRole, labelSelector: app=leftover}",
+			expectedError: "ClusterRole labeled app=leftover",
+		},
+		{
+			name:          "namespace of another component given a Service Catalog namespace",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {version: v1, resource: namespaces, kind: Namespace, namespace: openshift-service-catalog-controller-manager, name: openshift-etcd}",
+			expectedError: "cluster-wide namespaces in namespace openshift-service-catalog-controller-manager",
+		},
+		{
+			name:          "ClusterRole of another component given a Service Catalog namespace",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {group: rbac.authorization.k8s.io, version: v1, resource: clusterroles, kind: ClusterRole, namespace: openshift-service-catalog-apiserver, name: cluster-admin}",
+			expectedError: "cluster-wide clusterroles.rbac.authorization.k8s.io in namespace openshift-service-catalog-apiserver",
+		},
+		{
+			name:          "ClusterRole of Service Catalog given a Service Catalog namespace",
+			data:          "phases:\n- name: Removing\n  objects:\n  - {group: rbac.authorization.k8s.io, version: v1, resource: clusterroles, kind: ClusterRole, namespace: openshift-service-catalog-apiserver, name: \"{{.APIServer.RBACName}}\"}",
+			expectedError: "cluster-wide clusterroles.rbac.authorization.k8s.io in namespace openshift-service-catalog-apiserver",
+		},
+		{
+			name:          "another API group",
+			data:          "phases:\n- name: Removing\n  apiGroups:\n  - name: config.openshift.io",
+			expectedError: "API group config.openshift.io",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			inventoryConfigMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: RemovedNamespaceName, Name: "other-inventory"},
+				Data:       map[string]string{inventoryConfigMapKey: test.data},
+			}
+			if _, err := clients.kube.CoreV1().ConfigMaps(RemovedNamespaceName).Create(inventoryConfigMap); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			options := testOptions()
+			options.InventoryConfigMap = inventoryConfigMap.Name
+
+			report := clients.remover(options).Run()
+
+			if report.Outcome != ReportFailed || !strings.Contains(report.Message, test.expectedError) {
+				t.Errorf("expected outcome %s with a message containing %q, got %s: %s", ReportFailed, test.expectedError, report.Outcome, report.Message)
+			}
+			if deleted := clients.deletions(); len(deleted) > 0 {
+				t.Errorf("expected nothing to be deleted, got %v", deleted)
+			}
+		})
+	}
+}
+
+func TestRunWithMissingInventoryConfigMap(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	options := testOptions()
+	options.InventoryConfigMap = "missing"
+
+	report := clients.remover(options).Run()
+
+	if report.Outcome != ReportFailed {
+		t.Errorf("expected outcome %s, got %s: %s", ReportFailed, report.Outcome, report.Message)
+	}
+	if deleted := clients.deletions(); len(deleted) > 0 {
+		t.Errorf("expected nothing to be deleted, got %v", deleted)
+	}
+}
+
+func TestDeleteObjectRefusesNamespacedClusterWideObject(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	r := clients.remover(testOptions())
+	report := r.newReport()
+
+	for _, obj := range []inventoryObject{
+		{gvr: namespaceResource, kind: "Namespace", namespace: controllerManager.operandNamespace, name: "openshift-etcd"},
+		{gvr: clusterRoleResource, kind: "ClusterRole", namespace: apiServer.operandNamespace, name: "cluster-admin"},
+		{gvr: crdResource, kind: "CustomResourceDefinition", namespace: apiServer.operandNamespace, name: "clusterversions.config.openshift.io"},
+	} {
+		if err := r.deleteObject(report, obj); err == nil || !strings.Contains(err.Error(), "cluster-wide") {
+			t.Errorf("expected deleting %s to be refused, got %v", describeObject(obj.kind, obj.namespace, obj.name), err)
+		}
+	}
+	if deleted := clients.deletions(); len(deleted) > 0 {
+		t.Errorf("expected nothing to be deleted, got %v", deleted)
+	}
+	if len(report.Steps) != 3 {
+		t.Errorf("expected 3 steps, got %+v", report.Steps)
+	}
+	for _, step := range report.Steps {
+		if step.Outcome != StepFailed {
+			t.Errorf("expected step %+v to fail", step)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package remover
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// logLevels maps the log levels of the operator CRs, from the least to the
+// most verbose, to logrus levels. TraceAll also traces the bodies of the API
+// requests.
+var logLevels = []struct {
+	level  operatorapiv1.LogLevel
+	logrus log.Level
+}{
+	{operatorapiv1.Normal, log.InfoLevel},
+	{operatorapiv1.Debug, log.DebugLevel},
+	{operatorapiv1.Trace, log.TraceLevel},
+	{operatorapiv1.TraceAll, log.TraceLevel},
+}
+
+// verbosity returns the position of level in logLevels, -1 for a level that
+// is not understood.
+func verbosity(level operatorapiv1.LogLevel) int {
+	for i, l := range logLevels {
+		if l.level == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// traceBodies is set under TraceAll, for the round trippers returned by
+// NewTracingRoundTripper.
+var traceBodies int32
+
+// setLogLevel sets the logrus level, and the request body tracing, to level.
+func setLogLevel(level operatorapiv1.LogLevel) {
+	i := verbosity(level)
+	if i < 0 {
+		i = 0
+	}
+	log.SetLevel(logLevels[i].logrus)
+	var bodies int32
+	if logLevels[i].level == operatorapiv1.TraceAll {
+		bodies = 1
+	}
+	atomic.StoreInt32(&traceBodies, bodies)
+}
+
+// crLogLevel returns the log level the admin set on an operator CR: its
+// operatorLogLevel, which is about the operator itself, or else its logLevel.
+func crLogLevel(spec operatorapiv1.OperatorSpec) operatorapiv1.LogLevel {
+	if len(spec.OperatorLogLevel) > 0 {
+		return spec.OperatorLogLevel
+	}
+	return spec.LogLevel
+}
+
+// configuredLogLevel returns the most verbose log level set on the operator
+// CRs, Normal when they do not exist or set none.
+func (r *Remover) configuredLogLevel() (operatorapiv1.LogLevel, error) {
+	var specs []operatorapiv1.OperatorSpec
+	err := r.retryOnTransientError(func() error {
+		controllerManagerConfig, err := r.operatorClient.ServiceCatalogControllerManagers().Get(r.controllerManager.crName, metav1.GetOptions{})
+		if err == nil {
+			specs = append(specs, controllerManagerConfig.Spec.OperatorSpec)
+		}
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return operatorapiv1.Normal, fmt.Errorf("problem getting %s CR (%s): %v", r.controllerManager.crKind, classifyError(err), err)
+	}
+	err = r.retryOnTransientError(func() error {
+		apiServerConfig, err := r.operatorClient.ServiceCatalogAPIServers().Get(r.apiServer.crName, metav1.GetOptions{})
+		if err == nil {
+			specs = append(specs, apiServerConfig.Spec.OperatorSpec)
+		}
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return operatorapiv1.Normal, fmt.Errorf("problem getting %s CR (%s): %v", r.apiServer.crKind, classifyError(err), err)
+	}
+
+	level := operatorapiv1.Normal
+	for _, spec := range specs {
+		crLevel := crLogLevel(spec)
+		if len(crLevel) == 0 {
+			continue
+		}
+		if verbosity(crLevel) < 0 {
+			log.Warningf("ignoring unknown log level %q of the operator CRs", crLevel)
+			continue
+		}
+		if verbosity(crLevel) > verbosity(level) {
+			level = crLevel
+		}
+	}
+	return level, nil
+}
+
+// applyLogLevel sets the log verbosity to Options.LogLevel, or else to the
+// level set on the operator CRs. The CRs being unreadable is not a reason to
+// stop here: whatever reads them next reports it.
+func (r *Remover) applyLogLevel() {
+	level := r.options.LogLevel
+	if len(level) == 0 {
+		var err error
+		if level, err = r.configuredLogLevel(); err != nil {
+			log.Warningf("%v, keeping the %s log level", err, operatorapiv1.Normal)
+		}
+	}
+	setLogLevel(level)
+	log.Debugf("Logging at the %s log level", level)
+}
+
+// SetLogFormat sets the format of the logs: text, the default, or json, in
+// which the entries of the removal steps carry their phase, kind, name,
+// namespace, attempt and outcome as fields.
+func SetLogFormat(format string) error {
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// tracingRoundTripper logs every API request at the trace level.
+type tracingRoundTripper struct {
+	delegate http.RoundTripper
+}
+
+// NewTracingRoundTripper wraps rt to log the API requests going through it,
+// with their bodies under the TraceAll log level, except for Secrets, whose
+// bodies are never logged. Nothing is logged unless the trace level is
+// enabled.
+func NewTracingRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return &tracingRoundTripper{delegate: rt}
+}
+
+func (t *tracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !log.IsLevelEnabled(log.TraceLevel) {
+		return t.delegate.RoundTrip(req)
+	}
+	bodies := atomic.LoadInt32(&traceBodies) == 1 && !isSecretRequest(req)
+	if bodies && req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		log.Tracef("%s %s request body: %s", req.Method, req.URL, body)
+	}
+
+	start := time.Now()
+	resp, err := t.delegate.RoundTrip(req)
+	if err != nil {
+		log.Tracef("%s %s failed after %v: %v", req.Method, req.URL, time.Since(start), err)
+		return resp, err
+	}
+	log.Tracef("%s %s %s in %v", req.Method, req.URL, resp.Status, time.Since(start))
+	if bodies && resp.Body != nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		log.Tracef("%s %s response body: %s", req.Method, req.URL, body)
+	}
+	return resp, nil
+}
+
+// isSecretRequest tells whether req is about Secrets, a list of them or a
+// single one, in a namespace or across namespaces. The backups are Secrets,
+// and hold the Secrets of the removed namespaces.
+func isSecretRequest(req *http.Request) bool {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		// a namespace named secrets is not
+		if segment == "secrets" && (i == 0 || segments[i-1] != "namespaces") {
+			return true
+		}
+	}
+	return false
+}
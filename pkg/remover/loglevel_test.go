@@ -0,0 +1,253 @@
+package remover
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	configfake "github.com/openshift/client-go/config/clientset/versioned/fake"
+	operatorfake "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfiguredLogLevel(t *testing.T) {
+	tests := []struct {
+		name              string
+		controllerManager *operatorapiv1.OperatorSpec
+		apiServer         *operatorapiv1.OperatorSpec
+		expected          operatorapiv1.LogLevel
+	}{
+		{
+			name:     "no CRs",
+			expected: operatorapiv1.Normal,
+		},
+		{
+			name:              "no level set",
+			controllerManager: &operatorapiv1.OperatorSpec{},
+			apiServer:         &operatorapiv1.OperatorSpec{},
+			expected:          operatorapiv1.Normal,
+		},
+		{
+			name:              "operatorLogLevel",
+			controllerManager: &operatorapiv1.OperatorSpec{OperatorLogLevel: operatorapiv1.Debug, LogLevel: operatorapiv1.TraceAll},
+			expected:          operatorapiv1.Debug,
+		},
+		{
+			name:              "logLevel",
+			controllerManager: &operatorapiv1.OperatorSpec{LogLevel: operatorapiv1.Trace},
+			expected:          operatorapiv1.Trace,
+		},
+		{
+			name:              "most verbose of both CRs",
+			controllerManager: &operatorapiv1.OperatorSpec{OperatorLogLevel: operatorapiv1.Debug},
+			apiServer:         &operatorapiv1.OperatorSpec{OperatorLogLevel: operatorapiv1.TraceAll},
+			expected:          operatorapiv1.TraceAll,
+		},
+		{
+			name:              "unknown level",
+			controllerManager: &operatorapiv1.OperatorSpec{OperatorLogLevel: "Loud"},
+			apiServer:         &operatorapiv1.OperatorSpec{OperatorLogLevel: operatorapiv1.Debug},
+			expected:          operatorapiv1.Debug,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if test.controllerManager != nil {
+				objects = append(objects, &operatorapiv1.ServiceCatalogControllerManager{
+					ObjectMeta: metav1.ObjectMeta{Name: operatorConfigName},
+					Spec:       operatorapiv1.ServiceCatalogControllerManagerSpec{OperatorSpec: *test.controllerManager},
+				})
+			}
+			if test.apiServer != nil {
+				objects = append(objects, &operatorapiv1.ServiceCatalogAPIServer{
+					ObjectMeta: metav1.ObjectMeta{Name: operatorConfigName},
+					Spec:       operatorapiv1.ServiceCatalogAPIServerSpec{OperatorSpec: *test.apiServer},
+				})
+			}
+			r := New(kubefake.NewSimpleClientset(), operatorfake.NewSimpleClientset(objects...).OperatorV1(), configfake.NewSimpleClientset().ConfigV1(), newFakeDynamicClient(), DefaultOptions())
+
+			level, err := r.configuredLogLevel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if level != test.expected {
+				t.Errorf("expected log level %s, got %s", test.expected, level)
+			}
+		})
+	}
+}
+
+// roundTripperFunc serves requests with a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTracingRoundTripper(t *testing.T) {
+	tests := []struct {
+		url            string
+		expectedBodies bool
+	}{
+		{url: "https://api/api/v1/namespaces/kube-system/configmaps/report", expectedBodies: true},
+		{url: "https://api/api/v1/namespaces/secrets/configmaps", expectedBodies: true},
+		{url: "https://api/api/v1/namespaces/kube-system/secrets"},
+		{url: "https://api/api/v1/namespaces/kube-system/secrets/backup-0"},
+		{url: "https://api/api/v1/secrets?labelSelector=backup"},
+	}
+
+	level, output := log.GetLevel(), log.StandardLogger().Out
+	defer func() {
+		setLogLevel(operatorapiv1.Normal)
+		log.SetLevel(level)
+		log.SetOutput(output)
+	}()
+	setLogLevel(operatorapiv1.TraceAll)
+
+	rt := NewTracingRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("response-data"))}, nil
+	}))
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			req, err := http.NewRequest(http.MethodPut, test.url, strings.NewReader("request-data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the bodies are passed on, logged or not
+			if body, _ := ioutil.ReadAll(resp.Body); string(body) != "response-data" {
+				t.Errorf("expected the response body to be passed on, got %q", body)
+			}
+			for _, data := range []string{"request-data", "response-data"} {
+				if strings.Contains(logged.String(), data) != test.expectedBodies {
+					t.Errorf("expected %s to be logged %v, got %q", data, test.expectedBodies, logged.String())
+				}
+			}
+			if !strings.Contains(logged.String(), "200 OK") {
+				t.Errorf("expected the request to be logged, got %q", logged.String())
+			}
+		})
+	}
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer log.SetFormatter(log.StandardLogger().Formatter)
+
+	tests := []struct {
+		format        string
+		expectedError bool
+		expected      log.Formatter
+	}{
+		{format: "text", expected: &log.TextFormatter{}},
+		{format: "json", expected: &log.JSONFormatter{}},
+		{format: "yaml", expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			log.SetFormatter(&log.TextFormatter{DisableColors: true})
+
+			err := SetLogFormat(test.format)
+
+			if test.expectedError {
+				if err == nil || !strings.Contains(err.Error(), `unknown log format "yaml"`) {
+					t.Errorf("expected an unknown log format error, got %v", err)
+				}
+				if formatter, ok := log.StandardLogger().Formatter.(*log.TextFormatter); !ok || !formatter.DisableColors {
+					t.Errorf("expected the formatter to be left alone, got %#v", log.StandardLogger().Formatter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(log.StandardLogger().Formatter, test.expected) {
+				t.Errorf("expected formatter %#v, got %#v", test.expected, log.StandardLogger().Formatter)
+			}
+		})
+	}
+}
+
+func TestJSONLogsOfTrackedStep(t *testing.T) {
+	logger := log.StandardLogger()
+	defer func(formatter log.Formatter, out io.Writer, level log.Level) {
+		logger.SetFormatter(formatter)
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+	}(logger.Formatter, logger.Out, logger.Level)
+	if err := SetLogFormat("json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+	logger.SetLevel(log.InfoLevel)
+
+	options := testOptions()
+	options.RetryPolicy = RetryPolicy{Attempts: 2, InitialBackoff: time.Millisecond, BackoffFactor: 1}
+	r := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed).remover(options)
+	report := r.newReport().inPhase("RemovingLeftovers")
+	attempts := 0
+	err := report.track("ConfigMap", "leftovers", "settings", actionDelete, func() error {
+		attempts++
+		if attempts == 1 {
+			return apierrors.NewServiceUnavailable("try again")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		entry := map[string]interface{}{}
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("expected JSON log entries, got %q: %v", out.String(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected the step, its retry and its result to be logged, got %v", entries)
+	}
+	expected := map[string]interface{}{
+		"phase":     "RemovingLeftovers",
+		"kind":      "ConfigMap",
+		"name":      "settings",
+		"namespace": "leftovers",
+		"action":    actionDelete,
+	}
+	for i, entry := range entries {
+		for key, value := range expected {
+			if entry[key] != value {
+				t.Errorf("expected entry %d to have %s %v, got %v", i, key, value, entry)
+			}
+		}
+	}
+	if entries[1]["attempt"] != float64(1) {
+		t.Errorf("expected the retry to be logged with attempt 1, got %v", entries[1])
+	}
+	if entries[2]["attempt"] != float64(2) || entries[2]["outcome"] != string(StepSucceeded) {
+		t.Errorf("expected the result to be logged with attempt 2 and outcome %s, got %v", StepSucceeded, entries[2])
+	}
+}
@@ -0,0 +1,164 @@
+package remover
+
+import (
+	"fmt"
+	"strings"
+
+	configapiv1 "github.com/openshift/api/config/v1"
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// ManagedPolicy is what the remover does when Service Catalog is still
+// Managed.
+type ManagedPolicy string
+
+const (
+	// ManagedPolicyAbort removes nothing.
+	ManagedPolicyAbort ManagedPolicy = "abort"
+	// ManagedPolicyWait polls the operator CRs until an admin switches them
+	// away from Managed, then removes Service Catalog.
+	ManagedPolicyWait ManagedPolicy = "wait"
+	// ManagedPolicyRemove removes Service Catalog anyway.
+	ManagedPolicyRemove ManagedPolicy = "remove"
+)
+
+// managedReason is the reason of the ClusterOperator condition telling admins
+// to switch Service Catalog to Removed.
+const managedReason = "ServiceCatalogManaged"
+
+// managedComponents returns the halves of Service Catalog that are still
+// Managed.
+func (r *Remover) managedComponents(states ManagementStates) []component {
+	var managed []component
+	if states.ControllerManager == operatorapiv1.Managed {
+		managed = append(managed, r.controllerManager)
+	}
+	if states.APIServer == operatorapiv1.Managed {
+		managed = append(managed, r.apiServer)
+	}
+	return managed
+}
+
+// requiredAction tells admins how to let the removal of the component proceed.
+func requiredAction(c component) string {
+	return fmt.Sprintf(`Service Catalog is no longer supported and has to be removed: set the managementState of the %s %q to Removed with "oc patch %s %s --type merge -p '{\"spec\":{\"managementState\":\"Removed\"}}'"`,
+		c.crKind, c.crName, strings.TrimSuffix(c.crResource.Resource, "s"), c.crName)
+}
+
+// setClusterOperatorCondition sets the condition on the status of the
+// ClusterOperator, keeping its last transition time when its status does not
+// change. A missing ClusterOperator is left alone.
+func (r *Remover) setClusterOperatorCondition(name string, condition configapiv1.ClusterOperatorStatusCondition) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var co *configapiv1.ClusterOperator
+		err := r.retryOnTransientError(func() (err error) {
+			co, err = r.configClient.ClusterOperators().Get(name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		condition.LastTransitionTime = metav1.Now()
+		found := false
+		for i := range co.Status.Conditions {
+			existing := &co.Status.Conditions[i]
+			if existing.Type != condition.Type {
+				continue
+			}
+			found = true
+			if existing.Status == condition.Status {
+				condition.LastTransitionTime = existing.LastTransitionTime
+			}
+			*existing = condition
+		}
+		if !found {
+			co.Status.Conditions = append(co.Status.Conditions, condition)
+		}
+		return r.retryOnTransientError(func() error {
+			_, err := r.configClient.ClusterOperators().UpdateStatus(co)
+			return err
+		})
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// reportManagedState marks the ClusterOperator of every Managed half of
+// Service Catalog Upgradeable=False and records a Warning event, both with the
+// action admins have to take.
+func (r *Remover) reportManagedState(states ManagementStates) {
+	for _, c := range r.managedComponents(states) {
+		action := requiredAction(c)
+		log.Warning(action)
+		r.recorder.Warning(managedReason, action)
+		err := r.setClusterOperatorCondition(c.clusterOperatorName, configapiv1.ClusterOperatorStatusCondition{
+			Type:    configapiv1.OperatorUpgradeable,
+			Status:  configapiv1.ConditionFalse,
+			Reason:  managedReason,
+			Message: action,
+		})
+		if err != nil {
+			log.Errorf("problem setting the %s condition of ClusterOperator %s: %v", configapiv1.OperatorUpgradeable, c.clusterOperatorName, err)
+		}
+	}
+}
+
+// waitWhileManaged polls the operator CRs until neither is Managed any more.
+// The overall removal deadline is suspended while waiting: it bounds the
+// removal, not how long an admin takes to act.
+func (r *Remover) waitWhileManaged() (ManagementStates, error) {
+	r.retry.stop()
+	defer r.retry.start()
+
+	var states ManagementStates
+	condition := func() (bool, error) {
+		var err error
+		states, err = r.getManagementStates()
+		if err != nil {
+			log.Warningf("%v, still waiting", err)
+			return false, nil
+		}
+		return len(r.managedComponents(states)) == 0, nil
+	}
+	var err error
+	if r.options.ManagedWaitTimeout > 0 {
+		err = wait.PollImmediate(r.options.ManagedPollInterval, r.options.ManagedWaitTimeout, condition)
+	} else {
+		err = wait.PollImmediateInfinite(r.options.ManagedPollInterval, condition)
+	}
+	if err == wait.ErrWaitTimeout {
+		return states, fmt.Errorf("Service Catalog was still Managed after waiting %v", r.options.ManagedWaitTimeout)
+	}
+	return states, err
+}
+
+// applyManagedPolicy applies Options.ManagedPolicy to a removal aborted because
+// Service Catalog is still Managed, and returns the resulting management
+// states, decision and reason.
+func (r *Remover) applyManagedPolicy(states ManagementStates, reason string) (ManagementStates, removalDecision, string) {
+	switch r.options.ManagedPolicy {
+	case ManagedPolicyRemove:
+		log.Warningf("%s, removing anyway because of the %s policy", reason, ManagedPolicyRemove)
+		return states, decisionRemove, fmt.Sprintf("%s, removed because of the %s policy", reason, ManagedPolicyRemove)
+	case ManagedPolicyWait:
+		r.reportManagedState(states)
+		log.Warningf("%s, waiting for it to be switched to Removed", reason)
+		states, err := r.waitWhileManaged()
+		if err != nil {
+			return states, decisionFail, err.Error()
+		}
+		decision, reason := decideRemoval(states)
+		return states, decision, reason
+	default:
+		r.reportManagedState(states)
+		return states, decisionAbort, reason
+	}
+}
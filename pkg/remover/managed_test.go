@@ -0,0 +1,103 @@
+package remover
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	configapiv1 "github.com/openshift/api/config/v1"
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRunManagedPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ManagedPolicy
+		// removedAfter switches the controller manager CR to Removed once
+		// it has been read that many times, never if zero.
+		removedAfter    int
+		expectedOutcome ReportOutcome
+		expectedDeleted []string
+	}{
+		{
+			name:            "abort",
+			policy:          ManagedPolicyAbort,
+			expectedOutcome: ReportAborted,
+		},
+		{
+			name:            "remove",
+			policy:          ManagedPolicyRemove,
+			expectedOutcome: ReportSucceeded,
+			expectedDeleted: everything,
+		},
+		{
+			name:            "wait until Removed",
+			policy:          ManagedPolicyWait,
+			removedAfter:    3,
+			expectedOutcome: ReportSucceeded,
+			expectedDeleted: everything,
+		},
+		{
+			name:            "wait timing out",
+			policy:          ManagedPolicyWait,
+			expectedOutcome: ReportFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Managed, operatorapiv1.Removed)
+			if test.removedAfter > 0 {
+				reads := 0
+				clients.operator.PrependReactor("get", "servicecatalogcontrollermanagers", func(clienttesting.Action) (bool, runtime.Object, error) {
+					reads++
+					if reads < test.removedAfter {
+						return false, nil, nil
+					}
+					return true, &operatorapiv1.ServiceCatalogControllerManager{
+						ObjectMeta: metav1.ObjectMeta{Name: operatorConfigName},
+						Spec:       operatorapiv1.ServiceCatalogControllerManagerSpec{OperatorSpec: operatorapiv1.OperatorSpec{ManagementState: operatorapiv1.Removed}},
+					}, nil
+				})
+			}
+			options := testOptions()
+			options.ManagedPolicy = test.policy
+			options.ManagedPollInterval = time.Millisecond
+			options.ManagedWaitTimeout = 100 * time.Millisecond
+
+			report := clients.remover(options).Run()
+
+			if report.Outcome != test.expectedOutcome {
+				t.Errorf("expected outcome %s, got %s: %s", test.expectedOutcome, report.Outcome, report.Message)
+			}
+			if deleted := clients.deletions(); !reflect.DeepEqual(deleted, test.expectedDeleted) {
+				t.Errorf("expected deletions %v, got %v", test.expectedDeleted, deleted)
+			}
+			if test.expectedDeleted != nil {
+				return
+			}
+			// the admins are told to switch the Managed half to Removed
+			for _, c := range []component{controllerManager, apiServer} {
+				co, err := clients.config.ConfigV1().ClusterOperators().Get(c.clusterOperatorName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				var upgradeable *configapiv1.ClusterOperatorStatusCondition
+				for i := range co.Status.Conditions {
+					if co.Status.Conditions[i].Type == configapiv1.OperatorUpgradeable {
+						upgradeable = &co.Status.Conditions[i]
+					}
+				}
+				switch {
+				case c.crKind != controllerManager.crKind && upgradeable != nil:
+					t.Errorf("expected ClusterOperator %s to have no %s condition, got %+v", c.clusterOperatorName, configapiv1.OperatorUpgradeable, upgradeable)
+				case c.crKind == controllerManager.crKind && (upgradeable == nil || upgradeable.Status != configapiv1.ConditionFalse || upgradeable.Reason != managedReason):
+					t.Errorf("expected ClusterOperator %s to be %s=False with reason %s, got %+v", c.clusterOperatorName, configapiv1.OperatorUpgradeable, managedReason, upgradeable)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package remover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	namespaceResource          = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	clusterOperatorResource    = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusteroperators"}
+	clusterRoleBindingResource = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}
+	clusterRoleResource        = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+)
+
+// clusterScopedResources are the cluster-scoped resources the inventory can
+// refer to, whose objects have no namespace.
+var clusterScopedResources = map[schema.GroupResource]bool{
+	namespaceResource.GroupResource():            true,
+	controllerManager.crResource.GroupResource(): true,
+	apiServer.crResource.GroupResource():         true,
+	clusterOperatorResource.GroupResource():      true,
+	clusterRoleBindingResource.GroupResource():   true,
+	clusterRoleResource.GroupResource():          true,
+	apiServiceResource.GroupResource():           true,
+	crdResource.GroupResource():                  true,
+}
+
+// PlannedRemoval is a single object the remover would remove.
+type PlannedRemoval struct {
+	// Phase is the phase of the inventory the object is removed in.
+	Phase     string `json:"phase,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Exists is false for objects the remover would attempt to remove but
+	// which are already gone.
+	Exists bool `json:"exists"`
+	// StripFinalizer is set when finalizers whose controller is gone would be
+	// removed from the object before it is deleted.
+	StripFinalizer bool `json:"stripFinalizer,omitempty"`
+	// DryRun is the outcome of the server-side dry-run delete of the object.
+	DryRun string `json:"dryRun,omitempty"`
+}
+
+func (p PlannedRemoval) groupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+}
+
+// Plan is everything the remover would do given the current state of
+// the cluster.
+type Plan struct {
+	ManagementStates ManagementStates `json:"managementStates"`
+	// Proceed is false when the remover would abort without removing anything.
+	Proceed bool   `json:"proceed"`
+	Reason  string `json:"reason"`
+	// Usage is what tenants still have in the catalog.
+	Usage    *CatalogUsage    `json:"usage,omitempty"`
+	Removals []PlannedRemoval `json:"removals"`
+}
+
+// buildRemovalPlan resolves the managementState of both Service Catalog
+// operator CRs and enumerates, in removal order, every object of the inventory
+// the remover would remove.
+func (r *Remover) buildRemovalPlan() (*Plan, error) {
+	states, err := r.getManagementStates()
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{ManagementStates: states}
+	decision, reason := decideRemoval(states)
+	if decision == decisionAbort {
+		switch r.options.ManagedPolicy {
+		case ManagedPolicyRemove:
+			decision = decisionRemove
+			reason += fmt.Sprintf(", it would be removed anyway because of the %s policy", ManagedPolicyRemove)
+		case ManagedPolicyWait:
+			reason += ", removal would wait for it to be switched to Removed"
+		default:
+			reason += ", removal would be aborted"
+		}
+	} else if decision == decisionFail {
+		reason += ", removal would be aborted"
+	}
+	plan.Proceed = decision == decisionRemove
+	plan.Reason = reason
+	if !plan.Proceed {
+		return plan, nil
+	}
+	usage, blocked, err := r.checkCatalogUsage()
+	if err != nil {
+		return nil, fmt.Errorf("problem checking whether Service Catalog is still in use: %v", err)
+	}
+	plan.Usage = usage
+	if len(blocked) > 0 {
+		plan.Proceed = false
+		plan.Reason = blocked + ", removal would be blocked"
+		return plan, nil
+	}
+
+	inv, err := r.inventory()
+	if err != nil {
+		return nil, err
+	}
+	if plan.Removals, err = r.planRemovals(inv); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planRemovals enumerates, in removal order, every object of the inventory
+// the remover would remove.
+func (r *Remover) planRemovals(inv *inventory) ([]PlannedRemoval, error) {
+	var removals []PlannedRemoval
+	addAll := func(phase removalPhase, objects []inventoryObject) error {
+		objects, err := r.resolve(objects)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			found, err := r.get(obj)
+			if err != nil {
+				return err
+			}
+			removals = append(removals, PlannedRemoval{
+				Phase:          string(phase),
+				Group:          obj.gvr.Group,
+				Version:        obj.gvr.Version,
+				Resource:       obj.gvr.Resource,
+				Kind:           obj.kind,
+				Namespace:      obj.namespace,
+				Name:           obj.name,
+				Exists:         found != nil,
+				StripFinalizer: found != nil && hasFinalizerToStrip(*found, obj.policy.StripFinalizers),
+			})
+		}
+		return nil
+	}
+	addAPIGroup := func(phase removalPhase, g apiGroup) error {
+		var resources []schema.GroupVersionResource
+		err := r.retryOnTransientError(func() (err error) {
+			resources, err = discoverResources(r.discoveryClient, g)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("problem discovering %s resources: %v", g.Name, err)
+		}
+		for _, gvr := range resources {
+			var list *unstructured.UnstructuredList
+			err := r.retryOnTransientError(func() (err error) {
+				list, err = r.dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("problem listing %s: %v", gvr.Resource, err)
+			}
+			for _, obj := range list.Items {
+				removals = append(removals, PlannedRemoval{
+					Phase:          string(phase),
+					Group:          gvr.Group,
+					Version:        gvr.Version,
+					Resource:       gvr.Resource,
+					Kind:           obj.GetKind(),
+					Namespace:      obj.GetNamespace(),
+					Name:           obj.GetName(),
+					Exists:         true,
+					StripFinalizer: hasFinalizerToStrip(obj, g.StripFinalizers),
+				})
+			}
+		}
+		for _, registration := range []struct {
+			gvr  schema.GroupVersionResource
+			kind string
+		}{
+			{apiServiceResource, "APIService"},
+			{crdResource, "CustomResourceDefinition"},
+		} {
+			var objs []unstructured.Unstructured
+			err := r.retryOnTransientError(func() (err error) {
+				objs, err = listRegistrations(r.dynamicClient, registration.gvr, g.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("problem listing %s: %v", registration.gvr.Resource, err)
+			}
+			var registrations []inventoryObject
+			for _, obj := range objs {
+				registrations = append(registrations, inventoryObject{gvr: registration.gvr, kind: registration.kind, name: obj.GetName()})
+			}
+			if err := addAll(phase, registrations); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	phases, clusterOperators := inv.ordered(r.options.KeepClusterOperator)
+	for _, phase := range phases {
+		if err := addAll(phase.name, phase.objects); err != nil {
+			return nil, err
+		}
+		for _, g := range phase.apiGroups {
+			if err := addAPIGroup(phase.name, g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := addAll(phaseRemovingClusterOperators, clusterOperators); err != nil {
+		return nil, err
+	}
+	return removals, nil
+}
+
+// serverDryRunPlan asks the API server to validate the deletion of every
+// existing object in the plan without persisting it, and records the outcome
+// on the plan. Servers that do not support dry run report an error here.
+func (r *Remover) serverDryRunPlan(plan *Plan) {
+	for i := range plan.Removals {
+		removal := &plan.Removals[i]
+		if !removal.Exists {
+			continue
+		}
+		err := r.retryOnTransientError(func() error {
+			return resourceClient(r.dynamicClient, removal.groupVersionResource(), removal.Namespace).Delete(removal.Name, &metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}})
+		})
+		if err != nil {
+			removal.DryRun = fmt.Sprintf("failed: %v", err)
+		} else {
+			removal.DryRun = "passed"
+		}
+	}
+}
+
+// Plan enumerates, in removal order, every object Run would remove given the
+// current state of the cluster, validating the removal of each with a
+// server-side dry-run delete. Nothing is removed.
+func (r *Remover) Plan() (*Plan, error) {
+	r.retry.start()
+	r.applyLogLevel()
+	plan, err := r.buildRemovalPlan()
+	if err != nil {
+		return nil, err
+	}
+	r.serverDryRunPlan(plan)
+	return plan, nil
+}
+
+// PrintPlan writes the plan to out in the given format, text or json.
+func PrintPlan(out io.Writer, plan *Plan, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "text":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	fmt.Fprintf(out, "%s\n", plan.Reason)
+	if !plan.Proceed {
+		fmt.Fprintln(out, "Nothing would be removed.")
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tSTATUS\tDRY RUN")
+	for _, removal := range plan.Removals {
+		status := "remove"
+		switch {
+		case !removal.Exists:
+			status = "already removed"
+		case removal.StripFinalizer:
+			status = "strip finalizer, remove"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", removal.Kind, removal.Namespace, removal.Name, status, removal.DryRun)
+	}
+	return w.Flush()
+}
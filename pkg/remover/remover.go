@@ -0,0 +1,424 @@
+// Package remover removes Service Catalog, both its controller manager and its
+// API server, from an OpenShift cluster.
+package remover
+
+import (
+	"fmt"
+	"time"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	configv1 "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	operatorv1 "github.com/openshift/client-go/operator/clientset/versioned/typed/operator/v1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// operatorConfigName is the default name of the operator CR of both halves of
+// Service Catalog.
+const operatorConfigName = "cluster"
+
+// Options configures a Remover.
+type Options struct {
+	// RetryPolicy controls how API calls failing with a transient error are
+	// retried, and the overall deadline of the removal.
+	RetryPolicy RetryPolicy
+	// WaitForDeletion makes the remover wait for deleted namespaces and CRs
+	// to actually disappear, for at most DeletionTimeout each. Namespaces
+	// deleted by a phase other phases depend on are waited for regardless.
+	WaitForDeletion bool
+	DeletionTimeout time.Duration
+	// Backup makes the remover back up every object before removing
+	// anything, to BackupDir if set, to Secrets otherwise. Of the backups
+	// stored in Secrets, the latest BackupsKept are kept, zero keeping them
+	// all.
+	Backup      bool
+	BackupDir   string
+	BackupsKept int
+	// Force lets the removal proceed while the catalog is still in use.
+	Force bool
+	// KeepClusterOperator keeps the ClusterOperators of Service Catalog
+	// during the removal, reporting it through their Progressing and Degraded
+	// conditions, and only deletes them last, once everything else was
+	// removed.
+	KeepClusterOperator bool
+	// ManagedPolicy is what the remover does when Service Catalog is still
+	// Managed. Under ManagedPolicyWait the operator CRs are read every
+	// ManagedPollInterval, for at most ManagedWaitTimeout, zero meaning
+	// forever.
+	ManagedPolicy       ManagedPolicy
+	ManagedPollInterval time.Duration
+	ManagedWaitTimeout  time.Duration
+	// LogLevel overrides the log level, Normal, Debug, Trace or TraceAll,
+	// otherwise taken from the operator CRs.
+	LogLevel operatorapiv1.LogLevel
+	// CRName is the name of the operator CR of both halves of Service
+	// Catalog. TargetNamespace is the namespace of the operator of the
+	// controller manager, OperandNamespace that of the controller manager
+	// itself, ClusterOperatorName its ClusterOperator and RBACName the
+	// ClusterRole and ClusterRoleBinding of its operator; the APIServer ones
+	// are those of the API server. Empty names are the ones OpenShift
+	// installs.
+	CRName                       string
+	TargetNamespace              string
+	OperandNamespace             string
+	ClusterOperatorName          string
+	RBACName                     string
+	APIServerTargetNamespace     string
+	APIServerOperandNamespace    string
+	APIServerClusterOperatorName string
+	APIServerRBACName            string
+	// InventoryConfigMap is the ConfigMap, in RemovedNamespaceName, the
+	// inventory of what to remove is read from instead of the one the remover
+	// embeds. It may only remove objects of Service Catalog.
+	InventoryConfigMap string
+}
+
+// DefaultOptions returns the options the remover job runs with.
+func DefaultOptions() Options {
+	return Options{
+		RetryPolicy:         DefaultRetryPolicy(),
+		DeletionTimeout:     5 * time.Minute,
+		Backup:              true,
+		BackupsKept:         3,
+		ManagedPolicy:       ManagedPolicyAbort,
+		ManagedPollInterval: 30 * time.Second,
+	}
+}
+
+// Validate checks the options for values the remover does not understand.
+func (o Options) Validate() error {
+	switch o.ManagedPolicy {
+	case ManagedPolicyAbort, ManagedPolicyWait, ManagedPolicyRemove:
+	default:
+		return fmt.Errorf("unknown managed state policy %q, expected %s, %s or %s", o.ManagedPolicy, ManagedPolicyAbort, ManagedPolicyWait, ManagedPolicyRemove)
+	}
+	if o.DeletionTimeout <= 0 {
+		return fmt.Errorf("invalid deletion timeout %v, expected a positive duration", o.DeletionTimeout)
+	}
+	if o.BackupsKept < 0 {
+		return fmt.Errorf("invalid number of backups kept %d, expected 0 or more", o.BackupsKept)
+	}
+	if len(o.LogLevel) > 0 && verbosity(o.LogLevel) < 0 {
+		return fmt.Errorf("unknown log level %q, expected %s, %s, %s or %s", o.LogLevel, operatorapiv1.Normal, operatorapiv1.Debug, operatorapiv1.Trace, operatorapiv1.TraceAll)
+	}
+	return nil
+}
+
+// Remover removes Service Catalog through the clients it is built from.
+type Remover struct {
+	kubeClient      kubernetes.Interface
+	discoveryClient discovery.DiscoveryInterface
+	operatorClient  operatorv1.OperatorV1Interface
+	configClient    configv1.ConfigV1Interface
+	dynamicClient   dynamic.Interface
+
+	options Options
+	// retry is options.RetryPolicy, along with the deadline of the current
+	// removal.
+	retry    *RetryPolicy
+	recorder *eventRecorder
+
+	// controllerManager and apiServer are the halves of Service Catalog, with
+	// the names from options, and components lists both, the controller
+	// manager first: it is a client of the API server.
+	controllerManager component
+	apiServer         component
+	components        []component
+}
+
+// New returns a Remover using the given clients and options.
+func New(kubeClient kubernetes.Interface, operatorClient operatorv1.OperatorV1Interface, configClient configv1.ConfigV1Interface, dynamicClient dynamic.Interface, options Options) *Remover {
+	retry := options.RetryPolicy
+	r := &Remover{
+		kubeClient:      kubeClient,
+		discoveryClient: kubeClient.Discovery(),
+		operatorClient:  operatorClient,
+		configClient:    configClient,
+		dynamicClient:   dynamicClient,
+		options:         options,
+		retry:           &retry,
+
+		controllerManager: controllerManager.withNames(componentNames{
+			CRName:              options.CRName,
+			OperatorNamespace:   options.TargetNamespace,
+			OperandNamespace:    options.OperandNamespace,
+			ClusterOperatorName: options.ClusterOperatorName,
+			RBACName:            options.RBACName,
+		}),
+		apiServer: apiServer.withNames(componentNames{
+			CRName:              options.CRName,
+			OperatorNamespace:   options.APIServerTargetNamespace,
+			OperandNamespace:    options.APIServerOperandNamespace,
+			ClusterOperatorName: options.APIServerClusterOperatorName,
+			RBACName:            options.APIServerRBACName,
+		}),
+	}
+	r.components = []component{r.controllerManager, r.apiServer}
+	r.recorder = newEventRecorder(kubeClient, r.retry)
+	return r
+}
+
+func (r *Remover) newReport() *Report {
+	return &Report{StartTime: metav1.Now(), retry: r.retry}
+}
+
+// deleteObject deletes an object of the inventory, through the client of its
+// kind when the remover has one and the dynamic client otherwise, stripping
+// the finalizers its policy lists first. A cluster-wide object given a
+// namespace is refused: the clients of those kinds would ignore it.
+func (r *Remover) deleteObject(report *Report, obj inventoryObject) error {
+	if clusterScopedResources[obj.gvr.GroupResource()] && len(obj.namespace) > 0 {
+		return report.track(obj.kind, obj.namespace, obj.name, actionDelete, func() error {
+			return fmt.Errorf("%s is cluster-wide, it has no namespace", obj.gvr.GroupResource())
+		})
+	}
+	if len(obj.policy.StripFinalizers) > 0 {
+		err := report.track(obj.kind, obj.namespace, obj.name, actionStripFinalizer, func() error {
+			return stripFinalizers(resourceClient(r.dynamicClient, obj.gvr, obj.namespace), obj.name, obj.policy.StripFinalizers)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	options := obj.policy.deleteOptions()
+	return report.track(obj.kind, obj.namespace, obj.name, actionDelete, func() error {
+		switch obj.gvr {
+		case namespaceResource:
+			return r.kubeClient.CoreV1().Namespaces().Delete(obj.name, options)
+		case r.controllerManager.crResource:
+			return r.operatorClient.ServiceCatalogControllerManagers().Delete(obj.name, options)
+		case r.apiServer.crResource:
+			return r.operatorClient.ServiceCatalogAPIServers().Delete(obj.name, options)
+		case clusterOperatorResource:
+			return r.configClient.ClusterOperators().Delete(obj.name, options)
+		case clusterRoleBindingResource:
+			return r.kubeClient.RbacV1().ClusterRoleBindings().Delete(obj.name, options)
+		case clusterRoleResource:
+			return r.kubeClient.RbacV1().ClusterRoles().Delete(obj.name, options)
+		}
+		return resourceClient(r.dynamicClient, obj.gvr, obj.namespace).Delete(obj.name, options)
+	})
+}
+
+// deleteObjects removes the given objects of the inventory in order, those
+// picked by label selector being listed first. A failure to remove one object
+// does not prevent the others from being removed; all failures are returned
+// together. With Options.WaitForDeletion, or for namespaces when
+// waitForNamespaces is set, the objects are all deleted first and then waited
+// for, so that namespaces terminate concurrently, all within a single
+// deletionDeadline. Namespaces stuck terminating are diagnosed.
+func (r *Remover) deleteObjects(report *Report, objects []inventoryObject, waitForNamespaces bool) error {
+	var errs []error
+	var deleted []inventoryObject
+	for _, obj := range objects {
+		if len(obj.labelSelector) == 0 {
+			if err := r.deleteObject(report, obj); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			deleted = append(deleted, obj)
+			continue
+		}
+		var selected []inventoryObject
+		err := report.track(obj.kind, obj.namespace, obj.labelSelector, actionList, func() (err error) {
+			selected, err = r.selectObjects(obj)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, s := range selected {
+			if err := r.deleteObject(report, s); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			deleted = append(deleted, s)
+		}
+	}
+	deadline := r.deletionDeadline()
+	for _, obj := range deleted {
+		if !r.options.WaitForDeletion && !(waitForNamespaces && obj.gvr == namespaceResource) {
+			continue
+		}
+		err := r.trackDeletion(report, obj.gvr, obj.kind, obj.namespace, obj.name, deadline)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		if _, ok := err.(*stuckError); !ok || obj.gvr != namespaceResource {
+			continue
+		}
+		diagnostics, err := r.diagnoseNamespace(obj.name)
+		if err != nil {
+			log.Errorf("problem diagnosing stuck namespace [%s] :  %v", obj.name, err)
+			continue
+		}
+		logNamespaceDiagnostics(diagnostics)
+		report.addNamespaceDiagnostics(diagnostics)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// removePhase removes the objects of the phase, then its API groups. With
+// waitForNamespaces the namespaces it deletes are waited for.
+func (r *Remover) removePhase(report *Report, phase inventoryPhase, waitForNamespaces bool) {
+	if err := r.deleteObjects(report, phase.objects, waitForNamespaces); err != nil {
+		log.WithField("phase", phase.name).Errorf("problem removing objects: %v", err)
+	}
+	for _, g := range phase.apiGroups {
+		if err := r.removeAPIGroup(g, report); err != nil {
+			log.WithField("phase", phase.name).Errorf("problem removing %s resources: %v", g.Name, err)
+		}
+	}
+}
+
+// removePhases runs the phases, each once the phases it depends on are done,
+// so that phases independent of each other run in parallel. Deleting a
+// namespace only starts its termination: a phase other phases depend on is
+// done once the namespaces it deletes are gone, or DeletionTimeout or the
+// overall deadline passed, so
+// that for one an operator is gone before its operand is removed. The steps of
+// a phase are recorded in report with that phase. A failing or stuck step does
+// not keep the phases depending on its own from running.
+func (r *Remover) removePhases(report *Report, status *removalStatus, phases []inventoryPhase) {
+	waiting := map[removalPhase]int{}
+	dependents := map[removalPhase][]int{}
+	for i, phase := range phases {
+		waiting[phase.name] = len(phase.dependsOn)
+		for _, dependency := range phase.dependsOn {
+			dependents[dependency] = append(dependents[dependency], i)
+		}
+	}
+
+	done := make(chan removalPhase)
+	start := func(phase inventoryPhase) {
+		if len(phase.objects) == 0 && len(phase.apiGroups) == 0 {
+			go func() { done <- phase.name }()
+			return
+		}
+		// the conditions carry the phase that started last
+		status.progress(phase.name, phase.description)
+		go func() {
+			r.removePhase(report.inPhase(phase.name), phase, len(dependents[phase.name]) > 0)
+			done <- phase.name
+		}()
+	}
+	for _, phase := range phases {
+		if waiting[phase.name] == 0 {
+			start(phase)
+		}
+	}
+	for range phases {
+		name := <-done
+		for _, i := range dependents[name] {
+			waiting[phases[i].name]--
+			if waiting[phases[i].name] == 0 {
+				start(phases[i])
+			}
+		}
+	}
+}
+
+// removeServiceCatalog removes what the inventory lists, each phase once the
+// phases it depends on are done. For Service Catalog the operators go first,
+// then the controller manager, then the API resources while the API server
+// still serves them, then the API server itself, and finally the operator CRs
+// and ClusterOperators of both; the RBAC of the operators goes as soon as they
+// are gone. The progress is published through status until the objects
+// carrying it are deleted. With Options.KeepClusterOperator the
+// ClusterOperators go last, and only if everything else was removed.
+func (r *Remover) removeServiceCatalog(report *Report, status *removalStatus, inv *inventory) {
+	if r.options.KeepClusterOperator {
+		r.setRelatedObjects()
+	}
+	phases, clusterOperators := inv.ordered(r.options.KeepClusterOperator)
+	r.removePhases(report, status, phases)
+	if r.options.KeepClusterOperator {
+		r.deleteClusterOperatorsLast(report, status, clusterOperators)
+	}
+}
+
+// finishReport completes the report, records its outcome and persists it to
+// the report ConfigMap.
+func (r *Remover) finishReport(report *Report) {
+	report.complete()
+	log.WithField("outcome", report.Outcome).Infof("Removal %s: %s", report.Outcome, report.Message)
+	report.recorder.recordOutcome(report.Outcome, report.Message)
+	// the report is persisted even when the removal ran out of time
+	_, err := r.retry.withoutDeadline().do(func() error { return persistReport(r.kubeClient, report) })
+	if err != nil {
+		log.Errorf("problem persisting the removal report to ConfigMap %s/%s: %v", StateNamespaceName, reportConfigMapName, err)
+	}
+}
+
+// Run removes Service Catalog, unless it is still Managed or in use, and
+// returns the report of the removal. The report is also persisted to the
+// report ConfigMap.
+func (r *Remover) Run() *Report {
+	r.retry.start()
+	r.applyLogLevel()
+	report := r.newReport()
+	report.recorder = r.recorder
+	status := newRemovalStatus(r, report)
+
+	states, err := r.getManagementStates()
+	if err != nil {
+		// Without the CRs the remover cannot tell whether removal is wanted,
+		// so anything but NotFound aborts the removal.
+		log.Errorf("%v, aborting", err)
+		report.fail(err.Error())
+	} else {
+		report.ManagementStates = states
+		decision, reason := decideRemoval(states)
+		if decision == decisionAbort {
+			states, decision, reason = r.applyManagedPolicy(states, reason)
+			report.ManagementStates = states
+		}
+		switch decision {
+		case decisionRemove:
+			log.Info(reason)
+			usage, blocked, err := r.checkCatalogUsage()
+			if err != nil {
+				log.Errorf("problem checking whether Service Catalog is still in use: %v, aborting", err)
+				report.fail(fmt.Sprintf("problem checking whether Service Catalog is still in use: %v", err))
+				break
+			}
+			report.Usage = usage
+			if len(blocked) > 0 {
+				log.Warningf("%s. Aborting", blocked)
+				report.block(blocked)
+				break
+			}
+			inv, err := r.inventory()
+			if err != nil {
+				log.Errorf("%v, aborting", err)
+				report.fail(err.Error())
+				break
+			}
+			if r.options.Backup {
+				status.progress(phaseBackingUp, "Backing up every object before removing anything")
+				if err := r.backupBeforeRemoval(report, inv); err != nil {
+					report.fail(fmt.Sprintf("backup failed, nothing was removed: %v", err))
+					break
+				}
+			}
+			r.removeServiceCatalog(report, status, inv)
+			r.verifyRemoval(report, status, inv)
+		case decisionAbort:
+			log.Warningf("%s. Aborting", reason)
+			report.abort(reason)
+		default:
+			log.Error(reason)
+			report.fail(reason)
+		}
+	}
+
+	r.finishReport(report)
+	status.finish(report.Outcome, report.Message)
+	return report
+}
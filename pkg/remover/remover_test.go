@@ -0,0 +1,399 @@
+package remover
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	configapiv1 "github.com/openshift/api/config/v1"
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	configfake "github.com/openshift/client-go/config/clientset/versioned/fake"
+	operatorfake "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeClients holds the fake clientsets a Remover is built from in tests.
+type fakeClients struct {
+	kube     *kubefake.Clientset
+	operator *operatorfake.Clientset
+	config   *configfake.Clientset
+	dynamic  *dynamicfake.FakeDynamicClient
+}
+
+// newFakeClients returns clientsets holding Service Catalog with its operator
+// CRs in the states, leaving out a CR whose state is ManagementStateMissing.
+func newFakeClients(controllerManagerState, apiServerState operatorapiv1.ManagementState) *fakeClients {
+	kubeObjects := []runtime.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: RemovedNamespaceName}}}
+	var operatorObjects, configObjects []runtime.Object
+	for _, c := range []component{controllerManager, apiServer} {
+		kubeObjects = append(kubeObjects,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: c.operatorNamespace}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: c.operandNamespace}},
+			&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: c.rbacName}},
+			&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: c.rbacName}},
+		)
+		configObjects = append(configObjects, &configapiv1.ClusterOperator{ObjectMeta: metav1.ObjectMeta{Name: c.clusterOperatorName}})
+	}
+	if controllerManagerState != ManagementStateMissing {
+		operatorObjects = append(operatorObjects, &operatorapiv1.ServiceCatalogControllerManager{
+			ObjectMeta: metav1.ObjectMeta{Name: operatorConfigName},
+			Spec:       operatorapiv1.ServiceCatalogControllerManagerSpec{OperatorSpec: operatorapiv1.OperatorSpec{ManagementState: controllerManagerState}},
+		})
+	}
+	if apiServerState != ManagementStateMissing {
+		operatorObjects = append(operatorObjects, &operatorapiv1.ServiceCatalogAPIServer{
+			ObjectMeta: metav1.ObjectMeta{Name: operatorConfigName},
+			Spec:       operatorapiv1.ServiceCatalogAPIServerSpec{OperatorSpec: operatorapiv1.OperatorSpec{ManagementState: apiServerState}},
+		})
+	}
+
+	return &fakeClients{
+		kube:     kubefake.NewSimpleClientset(kubeObjects...),
+		operator: operatorfake.NewSimpleClientset(operatorObjects...),
+		config:   configfake.NewSimpleClientset(configObjects...),
+		dynamic:  newFakeDynamicClient(),
+	}
+}
+
+// newFakeDynamicClient returns a fake dynamic client holding the objects.
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	// the fake dynamic client lists every resource as this kind
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "fake-dynamic-client-group", Version: "v1", Kind: "List"}, &unstructured.UnstructuredList{})
+	return dynamicfake.NewSimpleDynamicClient(scheme, objects...)
+}
+
+// newObject returns an object for the fake dynamic client.
+func newObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// serveServiceCatalog makes the fake discovery serve the Service Catalog API
+// group, and the fake dynamic client hold the objects.
+func (c *fakeClients) serveServiceCatalog(objects ...runtime.Object) {
+	verbs := metav1.Verbs{"get", "list", "update", "delete"}
+	c.kube.Resources = append(c.kube.Resources, &metav1.APIResourceList{
+		GroupVersion: serviceCatalogGroup + "/v1beta1",
+		APIResources: []metav1.APIResource{
+			{Name: "clusterservicebrokers", Kind: "ClusterServiceBroker", Verbs: verbs},
+			{Name: "clusterserviceclasses", Kind: "ClusterServiceClass", Verbs: verbs},
+			{Name: "servicebrokers", Namespaced: true, Kind: "ServiceBroker", Verbs: verbs},
+			{Name: "serviceinstances", Namespaced: true, Kind: "ServiceInstance", Verbs: verbs},
+			{Name: "serviceinstances/status", Namespaced: true, Kind: "ServiceInstance", Verbs: metav1.Verbs{"get", "update"}},
+			{Name: "servicebindings", Namespaced: true, Kind: "ServiceBinding", Verbs: verbs},
+		},
+	})
+	c.dynamic = newFakeDynamicClient(objects...)
+}
+
+// newServiceCatalogObject returns a Service Catalog object carrying the
+// finalizer of its controller manager.
+func newServiceCatalogObject(kind, namespace, name string) *unstructured.Unstructured {
+	obj := newObject(serviceCatalogGroup+"/v1beta1", kind, namespace, name)
+	obj.SetFinalizers([]string{"kubernetes-incubator/service-catalog"})
+	return obj
+}
+
+// newRegistration returns an APIService or CRD of the Service Catalog API
+// group.
+func newRegistration(apiVersion, kind, name string) *unstructured.Unstructured {
+	obj := newObject(apiVersion, kind, "", name)
+	unstructured.SetNestedField(obj.Object, serviceCatalogGroup, "spec", "group")
+	return obj
+}
+
+// testOptions returns options that neither retry nor back up.
+func testOptions() Options {
+	options := DefaultOptions()
+	options.RetryPolicy = RetryPolicy{Attempts: 1}
+	options.Backup = false
+	return options
+}
+
+func (c *fakeClients) remover(options Options) *Remover {
+	return New(c.kube, c.operator.OperatorV1(), c.config.ConfigV1(), c.dynamic, options)
+}
+
+// deletions returns the resource/name of every delete recorded by the fake
+// clientsets, sorted.
+func (c *fakeClients) deletions() []string {
+	var deleted []string
+	for _, actions := range [][]clienttesting.Action{c.kube.Actions(), c.operator.Actions(), c.config.Actions(), c.dynamic.Actions()} {
+		for _, action := range actions {
+			if deleteAction, ok := action.(clienttesting.DeleteAction); ok && action.GetVerb() == "delete" {
+				deleted = append(deleted, fmt.Sprintf("%s/%s", action.GetResource().Resource, deleteAction.GetName()))
+			}
+		}
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+// everything is what a removal deletes, sorted.
+var everything = []string{
+	"clusteroperators/" + apiServer.clusterOperatorName,
+	"clusteroperators/" + controllerManager.clusterOperatorName,
+	"clusterrolebindings/" + apiServer.rbacName,
+	"clusterrolebindings/" + controllerManager.rbacName,
+	"clusterroles/" + apiServer.rbacName,
+	"clusterroles/" + controllerManager.rbacName,
+	"namespaces/" + apiServer.operandNamespace,
+	"namespaces/" + apiServer.operatorNamespace,
+	"namespaces/" + controllerManager.operandNamespace,
+	"namespaces/" + controllerManager.operatorNamespace,
+	"servicecatalogapiservers/" + operatorConfigName,
+	"servicecatalogcontrollermanagers/" + operatorConfigName,
+}
+
+func TestRun(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "test"}, "test", fmt.Errorf("not allowed"))
+
+	tests := []struct {
+		name                   string
+		controllerManagerState operatorapiv1.ManagementState
+		apiServerState         operatorapiv1.ManagementState
+		// reactors are prepended to the fake clientsets before the removal.
+		reactors        func(c *fakeClients)
+		expectedOutcome ReportOutcome
+		expectedExit    int
+		expectedDeleted []string
+		expectedFailed  []string
+	}{
+		{
+			name:                   "managed",
+			controllerManagerState: operatorapiv1.Managed,
+			apiServerState:         operatorapiv1.Managed,
+			expectedOutcome:        ReportAborted,
+			expectedExit:           ExitAborted,
+		},
+		{
+			name:                   "controller manager managed, API server removed",
+			controllerManagerState: operatorapiv1.Managed,
+			apiServerState:         operatorapiv1.Removed,
+			expectedOutcome:        ReportAborted,
+			expectedExit:           ExitAborted,
+		},
+		{
+			name:                   "unmanaged",
+			controllerManagerState: operatorapiv1.Unmanaged,
+			apiServerState:         operatorapiv1.Unmanaged,
+			expectedOutcome:        ReportSucceeded,
+			expectedExit:           ExitSucceeded,
+			expectedDeleted:        everything,
+		},
+		{
+			name:                   "removed",
+			controllerManagerState: operatorapiv1.Removed,
+			apiServerState:         operatorapiv1.Removed,
+			expectedOutcome:        ReportSucceeded,
+			expectedExit:           ExitSucceeded,
+			expectedDeleted:        everything,
+		},
+		{
+			name:                   "unknown state",
+			controllerManagerState: "Bogus",
+			apiServerState:         operatorapiv1.Removed,
+			expectedOutcome:        ReportFailed,
+			expectedExit:           ExitFailed,
+		},
+		{
+			name:                   "no state",
+			controllerManagerState: "",
+			apiServerState:         operatorapiv1.Removed,
+			expectedOutcome:        ReportFailed,
+			expectedExit:           ExitFailed,
+		},
+		{
+			name:                   "CRs not found",
+			controllerManagerState: ManagementStateMissing,
+			apiServerState:         ManagementStateMissing,
+			expectedOutcome:        ReportSucceeded,
+			expectedExit:           ExitSucceeded,
+			// the CRs are deleted anyway, which finds them already gone
+			expectedDeleted: everything,
+		},
+		{
+			name:                   "API server CR not found",
+			controllerManagerState: operatorapiv1.Removed,
+			apiServerState:         ManagementStateMissing,
+			expectedOutcome:        ReportSucceeded,
+			expectedExit:           ExitSucceeded,
+			expectedDeleted:        everything,
+		},
+		{
+			name:                   "get error",
+			controllerManagerState: operatorapiv1.Removed,
+			apiServerState:         operatorapiv1.Removed,
+			reactors: func(c *fakeClients) {
+				c.operator.PrependReactor("get", "servicecatalogcontrollermanagers", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, forbidden
+				})
+			},
+			expectedOutcome: ReportFailed,
+			expectedExit:    ExitFailed,
+		},
+		{
+			name:                   "delete failure",
+			controllerManagerState: operatorapiv1.Removed,
+			apiServerState:         operatorapiv1.Removed,
+			reactors: func(c *fakeClients) {
+				c.kube.PrependReactor("delete", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					if action.(clienttesting.DeleteAction).GetName() == controllerManager.operandNamespace {
+						return true, nil, forbidden
+					}
+					return false, nil, nil
+				})
+			},
+			expectedOutcome: ReportPartiallyFailed,
+			expectedExit:    ExitPartiallyFailed,
+			// a failure does not stop the removal
+			expectedDeleted: everything,
+			expectedFailed:  []string{"Namespace " + controllerManager.operandNamespace},
+		},
+		{
+			name:                   "every delete fails",
+			controllerManagerState: operatorapiv1.Removed,
+			apiServerState:         operatorapiv1.Removed,
+			reactors: func(c *fakeClients) {
+				fail := func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, forbidden
+				}
+				c.kube.PrependReactor("delete", "*", fail)
+				c.operator.PrependReactor("delete", "*", fail)
+				c.config.PrependReactor("delete", "*", fail)
+				c.dynamic.PrependReactor("list", "*", fail)
+			},
+			// the API group lookup, which only reads, succeeds as the fake
+			// discovery still serves, but nothing was removed
+			expectedOutcome: ReportFailed,
+			expectedExit:    ExitFailed,
+			expectedDeleted: everything,
+			expectedFailed: []string{
+				"Namespace " + controllerManager.operatorNamespace,
+				"Namespace " + controllerManager.operandNamespace,
+				"Namespace " + apiServer.operatorNamespace,
+				"apiservices *",
+				"customresourcedefinitions *",
+				"Namespace " + apiServer.operandNamespace,
+				controllerManager.crKind + " " + operatorConfigName,
+				apiServer.crKind + " " + operatorConfigName,
+				"ClusterOperator " + controllerManager.clusterOperatorName,
+				"ClusterOperator " + apiServer.clusterOperatorName,
+				"ClusterRoleBinding " + controllerManager.rbacName,
+				"ClusterRole " + controllerManager.rbacName,
+				"ClusterRoleBinding " + apiServer.rbacName,
+				"ClusterRole " + apiServer.rbacName,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(test.controllerManagerState, test.apiServerState)
+			if test.reactors != nil {
+				test.reactors(clients)
+			}
+
+			report := clients.remover(testOptions()).Run()
+
+			if report.Outcome != test.expectedOutcome {
+				t.Errorf("expected outcome %s, got %s: %s", test.expectedOutcome, report.Outcome, report.Message)
+			}
+			if exitCode := report.ExitCode(); exitCode != test.expectedExit {
+				t.Errorf("expected exit code %d, got %d", test.expectedExit, exitCode)
+			}
+			if deleted := clients.deletions(); !reflect.DeepEqual(deleted, test.expectedDeleted) {
+				t.Errorf("expected deletions %v, got %v", test.expectedDeleted, deleted)
+			}
+			// the checklist only runs once something was removed
+			if removed := test.expectedDeleted != nil; removed != (report.Verification != nil) {
+				t.Errorf("expected a verification %v, got %v", removed, report.Verification != nil)
+			}
+			// independent phases run in parallel, their steps in any order
+			var failed []string
+			for _, step := range report.failedSteps() {
+				failed = append(failed, describeObject(step.Kind, step.Namespace, step.Name))
+			}
+			sort.Strings(failed)
+			sort.Strings(test.expectedFailed)
+			if !reflect.DeepEqual(failed, test.expectedFailed) {
+				t.Errorf("expected failed steps %v, got %v", test.expectedFailed, failed)
+			}
+
+			// the report outlives the removal, whatever its outcome
+			lastReport, err := clients.remover(testOptions()).LastReport()
+			if err != nil {
+				t.Errorf("expected the report to be persisted: %v", err)
+			} else if lastReport.Outcome != report.Outcome || len(lastReport.Steps) != len(report.Steps) {
+				t.Errorf("expected the persisted report to match, got outcome %s with %d steps", lastReport.Outcome, len(lastReport.Steps))
+			}
+
+			// the API server rejects events in another namespace than their
+			// involved object
+			events, err := clients.kube.CoreV1().Events(RemovedNamespaceName).List(metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(events.Items) == 0 {
+				t.Errorf("expected events to be recorded")
+			}
+			for _, event := range events.Items {
+				if event.InvolvedObject.Namespace != event.Namespace {
+					t.Errorf("expected event %s to involve an object in namespace %s, got %+v", event.Name, event.Namespace, event.InvolvedObject)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithNames(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	options := testOptions()
+	options.CRName = "other"
+	options.TargetNamespace = "other-controller-manager-operator"
+	options.OperandNamespace = "other-controller-manager"
+	options.ClusterOperatorName = "other-controller-manager"
+	options.RBACName = "other-controller-manager-operator"
+	options.APIServerTargetNamespace = "other-apiserver-operator"
+	options.APIServerOperandNamespace = "other-apiserver"
+	options.APIServerClusterOperatorName = "other-apiserver"
+	options.APIServerRBACName = "other-apiserver-operator"
+
+	report := clients.remover(options).Run()
+
+	if report.Outcome != ReportSucceeded {
+		t.Errorf("expected outcome %s, got %s: %s", ReportSucceeded, report.Outcome, report.Message)
+	}
+	expected := []string{
+		"clusteroperators/other-apiserver",
+		"clusteroperators/other-controller-manager",
+		"clusterrolebindings/other-apiserver-operator",
+		"clusterrolebindings/other-controller-manager-operator",
+		"clusterroles/other-apiserver-operator",
+		"clusterroles/other-controller-manager-operator",
+		"namespaces/other-apiserver",
+		"namespaces/other-apiserver-operator",
+		"namespaces/other-controller-manager",
+		"namespaces/other-controller-manager-operator",
+		"servicecatalogapiservers/other",
+		"servicecatalogcontrollermanagers/other",
+	}
+	if deleted := clients.deletions(); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected deletions %v, got %v", expected, deleted)
+	}
+}
@@ -0,0 +1,467 @@
+package remover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RemovedNamespaceName is the namespace the remover job runs in. The
+	// release payload deletes it, together with the job, once the job is done.
+	RemovedNamespaceName = "openshift-service-catalog-removed"
+	// StateNamespaceName is the namespace the remover keeps the report and
+	// the backups in, which unlike RemovedNamespaceName is never deleted.
+	StateNamespaceName  = "kube-system"
+	reportConfigMapName = "service-catalog-controller-manager-removal-report"
+	reportConfigMapKey  = "report.json"
+
+	// maxTerminationMessageSize is the size limit the kubelet applies to
+	// container termination messages.
+	maxTerminationMessageSize = 4096
+)
+
+const (
+	actionDelete         = "Delete"
+	actionStripFinalizer = "StripFinalizer"
+	actionList           = "List"
+	actionVerify         = "Verify"
+)
+
+type StepOutcome string
+
+const (
+	StepSucceeded StepOutcome = "Succeeded"
+	// StepNotFound means there was nothing to do, the object was already
+	// gone.
+	StepNotFound StepOutcome = "NotFound"
+	StepFailed   StepOutcome = "Failed"
+	// StepStuck means the object was deleted but did not disappear in
+	// time. It counts as a failure.
+	StepStuck StepOutcome = "Stuck"
+)
+
+type ReportOutcome string
+
+const (
+	ReportSucceeded ReportOutcome = "Succeeded"
+	// ReportPartiallyFailed means some steps failed while others did not.
+	ReportPartiallyFailed ReportOutcome = "PartiallyFailed"
+	// ReportFailed means every step changing the cluster failed, or the
+	// remover could not get to the point of removing anything.
+	ReportFailed ReportOutcome = "Failed"
+	// ReportAborted means the remover refused to remove anything because the
+	// Service Catalog is still Managed.
+	ReportAborted ReportOutcome = "Aborted"
+	// ReportBlocked means the remover refused to remove anything because
+	// tenants still use Service Catalog and the data loss was not
+	// acknowledged.
+	ReportBlocked ReportOutcome = "Blocked"
+)
+
+// Process exit codes. Anything but ExitSucceeded marks the remover Job as
+// failed, so its backoffLimit retries the removal.
+const (
+	ExitSucceeded       = 0
+	ExitFailed          = 1
+	ExitPartiallyFailed = 2
+	ExitAborted         = 3
+	ExitBlocked         = 4
+)
+
+// StepResult is the result of a single removal step.
+type StepResult struct {
+	// Phase is the phase of the removal the step was part of.
+	Phase     string      `json:"phase,omitempty"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	Action    string      `json:"action"`
+	Outcome   StepOutcome `json:"outcome"`
+	Error     string      `json:"error,omitempty"`
+	// ErrorClass is the classification of Error.
+	ErrorClass ErrorClass `json:"errorClass,omitempty"`
+	// Attempts is the number of times the API call was made.
+	Attempts int             `json:"attempts"`
+	Duration metav1.Duration `json:"duration"`
+}
+
+// Report aggregates the results of every step of a removal.
+type Report struct {
+	StartTime        metav1.Time      `json:"startTime"`
+	CompletionTime   metav1.Time      `json:"completionTime,omitempty"`
+	ManagementStates ManagementStates `json:"managementStates"`
+	Outcome          ReportOutcome    `json:"outcome"`
+	Message          string           `json:"message,omitempty"`
+	// Usage is what tenants still had in the catalog before the removal.
+	Usage *CatalogUsage `json:"usage,omitempty"`
+	// BackupID is the id of the backup taken before anything was removed,
+	// stored at BackupLocation. A removal retrying a failed one keeps its
+	// backup.
+	BackupID       string       `json:"backupID,omitempty"`
+	BackupLocation string       `json:"backupLocation,omitempty"`
+	Steps          []StepResult `json:"steps"`
+	// NamespaceDiagnostics explain the namespaces that got stuck terminating.
+	NamespaceDiagnostics []NamespaceDiagnostics `json:"namespaceDiagnostics,omitempty"`
+	// Verification is the checklist run once everything was removed.
+	Verification *Verification `json:"verification,omitempty"`
+
+	// phase is the current phase of the removal, which the steps are logged
+	// and recorded with.
+	phase removalPhase
+	// parent, set on the report of a phase running alongside others, is the
+	// report the steps are recorded in.
+	parent *Report
+	// retry is the retry policy of the steps.
+	retry *RetryPolicy
+	// recorder, if set, records an event for every step.
+	recorder *eventRecorder
+	lock     sync.Mutex
+}
+
+func describeObject(kind, namespace, name string) string {
+	if len(namespace) == 0 {
+		return fmt.Sprintf("%s %s", kind, name)
+	}
+	return fmt.Sprintf("%s %s/%s", kind, namespace, name)
+}
+
+// track runs fn as a single removal step, retrying transient errors, then
+// logs and records its result. fn returns the error of the API call it makes;
+// a NotFound error means the object was already gone and is not treated as a
+// failure.
+func (r *Report) track(kind, namespace, name, action string, fn func() error) error {
+	object := describeObject(kind, namespace, name)
+	phase := r.currentPhase()
+	entry := log.WithFields(log.Fields{"kind": kind, "name": name, "action": action})
+	if len(namespace) > 0 {
+		entry = entry.WithField("namespace", namespace)
+	}
+	if len(phase) > 0 {
+		entry = entry.WithField("phase", phase)
+	}
+	entry.Infof("%s %s", action, object)
+
+	start := time.Now()
+	attempts, err := r.retry.doLogged(entry, fn)
+	result := StepResult{
+		Phase:      string(phase),
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+		Action:     action,
+		ErrorClass: classifyError(err),
+		Attempts:   attempts,
+		Duration:   metav1.Duration{Duration: time.Since(start)},
+	}
+	entry = entry.WithField("attempt", attempts)
+	switch result.ErrorClass {
+	case "":
+		result.Outcome = StepSucceeded
+		entry.WithField("outcome", result.Outcome).Infof("%s %s succeeded", action, object)
+	case ErrorClassNotFound:
+		result.Outcome = StepNotFound
+		result.ErrorClass = ""
+		entry.WithField("outcome", result.Outcome).Infof("%s %s: already removed", action, object)
+		err = nil
+	default:
+		result.Outcome = StepFailed
+		if _, ok := err.(*stuckError); ok {
+			result.Outcome = StepStuck
+		}
+		result.Error = err.Error()
+		entry.WithFields(log.Fields{"outcome": result.Outcome, "errorClass": result.ErrorClass}).Errorf("problem with %s %s (%s): %v", action, object, result.ErrorClass, err)
+	}
+
+	r.recorder.recordStep(result)
+
+	root := r.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.Steps = append(root.Steps, result)
+	return err
+}
+
+// inPhase returns a report for the steps of a phase running alongside
+// others: they are recorded in r, with that phase whatever the current phase
+// of r is.
+func (r *Report) inPhase(phase removalPhase) *Report {
+	return &Report{phase: phase, parent: r.root(), retry: r.retry, recorder: r.recorder}
+}
+
+func (r *Report) root() *Report {
+	if r.parent != nil {
+		return r.parent
+	}
+	return r
+}
+
+// setPhase sets the phase the following steps are part of.
+func (r *Report) setPhase(phase removalPhase) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.phase = phase
+}
+
+func (r *Report) currentPhase() removalPhase {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.phase
+}
+
+func (r *Report) addNamespaceDiagnostics(diagnostics *NamespaceDiagnostics) {
+	root := r.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.NamespaceDiagnostics = append(root.NamespaceDiagnostics, *diagnostics)
+}
+
+// abort marks the report as aborted: nothing is going to be removed.
+func (r *Report) abort(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Outcome = ReportAborted
+	r.Message = message
+}
+
+// block marks the report as blocked: nothing is going to be removed because
+// the catalog is still in use.
+func (r *Report) block(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Outcome = ReportBlocked
+	r.Message = message
+}
+
+// setBackup records the backup taken before the removal.
+func (r *Report) setBackup(id, location string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.BackupID = id
+	r.BackupLocation = location
+}
+
+// fail marks the report as failed before anything could be removed.
+func (r *Report) fail(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Outcome = ReportFailed
+	r.Message = message
+}
+
+// failedSteps returns the steps that failed or got stuck so far.
+func (r *Report) failedSteps() []StepResult {
+	root := r.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	var failed []StepResult
+	for _, step := range root.Steps {
+		if step.Outcome == StepFailed || step.Outcome == StepStuck {
+			failed = append(failed, step)
+		}
+	}
+	return failed
+}
+
+// mutatingActions are the actions of the steps that change the cluster. The
+// outcome of a report is Failed when all of them failed, whatever the steps
+// that only read did.
+var mutatingActions = map[string]bool{
+	actionDelete:          true,
+	actionStripFinalizer:  true,
+	actionWaitForDeletion: true,
+	actionRestore:         true,
+}
+
+// complete sets the completion time and, unless the removal was aborted or
+// failed early, the outcome derived from the recorded steps.
+func (r *Report) complete() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.CompletionTime = metav1.Now()
+	if len(r.Outcome) > 0 {
+		return
+	}
+	failed, mutating, mutatingFailed := 0, 0, 0
+	for _, step := range r.Steps {
+		stepFailed := step.Outcome == StepFailed || step.Outcome == StepStuck
+		if stepFailed {
+			failed++
+		}
+		if mutatingActions[step.Action] {
+			mutating++
+			if stepFailed {
+				mutatingFailed++
+			}
+		}
+	}
+	switch {
+	case failed == 0:
+		r.Outcome = ReportSucceeded
+		r.Message = fmt.Sprintf("%d steps succeeded", len(r.Steps))
+	case mutatingFailed == mutating:
+		r.Outcome = ReportFailed
+		r.Message = fmt.Sprintf("%d of %d steps failed, changing nothing", failed, len(r.Steps))
+	default:
+		r.Outcome = ReportPartiallyFailed
+		r.Message = fmt.Sprintf("%d of %d steps failed", failed, len(r.Steps))
+	}
+}
+
+// ExitCode maps the outcome of a completed report to the process exit code.
+func (r *Report) ExitCode() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch r.Outcome {
+	case ReportSucceeded:
+		return ExitSucceeded
+	case ReportPartiallyFailed:
+		return ExitPartiallyFailed
+	case ReportAborted:
+		return ExitAborted
+	case ReportBlocked:
+		return ExitBlocked
+	default:
+		return ExitFailed
+	}
+}
+
+func (r *Report) marshal() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return json.Marshal(r)
+}
+
+// terminationMessage returns the report as JSON if it fits the termination
+// message size limit, otherwise a summary with only the failed steps.
+func (r *Report) terminationMessage() ([]byte, error) {
+	data, err := r.marshal()
+	if err != nil || len(data) <= maxTerminationMessageSize {
+		return data, err
+	}
+
+	r.lock.Lock()
+	summary := struct {
+		Outcome     ReportOutcome `json:"outcome"`
+		Message     string        `json:"message,omitempty"`
+		Report      string        `json:"report"`
+		FailedSteps []StepResult  `json:"failedSteps,omitempty"`
+	}{
+		Outcome: r.Outcome,
+		Message: r.Message,
+		Report:  StateNamespaceName + "/" + reportConfigMapName,
+	}
+	for _, step := range r.Steps {
+		if step.Outcome == StepFailed || step.Outcome == StepStuck {
+			summary.FailedSteps = append(summary.FailedSteps, step)
+		}
+	}
+	r.lock.Unlock()
+
+	data, err = json.Marshal(summary)
+	if err != nil || len(data) <= maxTerminationMessageSize {
+		return data, err
+	}
+	summary.FailedSteps = nil
+	return json.Marshal(summary)
+}
+
+// WriteTerminationMessage writes the report to the container termination
+// message file so that it shows up in the pod and Job status.
+func (r *Report) WriteTerminationMessage(path string) error {
+	data, err := r.terminationMessage()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// persistReport stores the report in a ConfigMap of StateNamespaceName, where
+// it outlives the remover job and its namespace.
+func persistReport(kubeClient kubernetes.Interface, report *Report) error {
+	data, err := report.marshal()
+	if err != nil {
+		return err
+	}
+	configMaps := kubeClient.CoreV1().ConfigMaps(StateNamespaceName)
+	configMap, err := configMaps.Get(reportConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: reportConfigMapName, Namespace: StateNamespaceName},
+			Data:       map[string]string{reportConfigMapKey: string(data)},
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+	configMap.Data = map[string]string{reportConfigMapKey: string(data)}
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// LastReport reads the report of the last removal back from the report
+// ConfigMap.
+func (r *Remover) LastReport() (*Report, error) {
+	var configMap *corev1.ConfigMap
+	err := r.retryOnTransientError(func() (err error) {
+		configMap, err = r.kubeClient.CoreV1().ConfigMaps(StateNamespaceName).Get(reportConfigMapName, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("no removal report in ConfigMap %s/%s, the remover has not run yet", StateNamespaceName, reportConfigMapName)
+	} else if err != nil {
+		return nil, fmt.Errorf("problem getting ConfigMap %s/%s: %v", StateNamespaceName, reportConfigMapName, err)
+	}
+	report := &Report{}
+	if err := json.Unmarshal([]byte(configMap.Data[reportConfigMapKey]), report); err != nil {
+		return nil, fmt.Errorf("problem decoding the removal report in ConfigMap %s/%s: %v", StateNamespaceName, reportConfigMapName, err)
+	}
+	return report, nil
+}
+
+// PrintReport writes the report to out in the given format: text, a summary
+// with the failed steps, or json.
+func PrintReport(out io.Writer, report *Report, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "text":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	fmt.Fprintf(out, "Removal %s: %s\n", report.Outcome, report.Message)
+	fmt.Fprintf(out, "Started %s, completed %s\n", report.StartTime.Format(time.RFC3339), report.CompletionTime.Format(time.RFC3339))
+	if v := report.Verification; v != nil {
+		for _, check := range v.Checks {
+			if check.Result != CheckPassed {
+				fmt.Fprintf(out, "Check %q %s: %s\n", check.Description, check.Result, check.Message)
+			}
+		}
+	}
+	failed := report.failedSteps()
+	if len(failed) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tACTION\tOUTCOME\tERROR")
+	for _, step := range failed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", step.Kind, step.Namespace, step.Name, step.Action, step.Outcome, step.Error)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,128 @@
+package remover
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// errDeadlineExceeded is returned for calls that were not made, or not
+// retried, because the overall removal deadline has passed.
+var errDeadlineExceeded = errors.New("removal deadline exceeded")
+
+// RetryPolicy controls how API calls failing with a retryable error are
+// retried.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times a call is made, including the
+	// first one.
+	Attempts int
+	// InitialBackoff is the wait before the first retry. Every following wait
+	// is BackoffFactor times longer, up to MaxBackoff.
+	InitialBackoff time.Duration
+	BackoffFactor  float64
+	MaxBackoff     time.Duration
+	// Jitter randomly lengthens every wait by up to Jitter times its duration.
+	Jitter float64
+	// Timeout is the overall deadline of the removal, counted from start.
+	// Zero means no deadline.
+	Timeout time.Duration
+
+	deadline time.Time
+}
+
+// DefaultRetryPolicy returns the retry policy the remover job runs with.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:       5,
+		InitialBackoff: time.Second,
+		BackoffFactor:  2.0,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.1,
+		Timeout:        10 * time.Minute,
+	}
+}
+
+// start sets the overall deadline.
+func (p *RetryPolicy) start() {
+	if p.Timeout > 0 {
+		p.deadline = time.Now().Add(p.Timeout)
+	}
+}
+
+// stop clears the deadline until start is called again.
+func (p *RetryPolicy) stop() {
+	p.deadline = time.Time{}
+}
+
+// remaining returns the time left until the deadline and whether there is a
+// deadline at all.
+func (p *RetryPolicy) remaining() (time.Duration, bool) {
+	if p.deadline.IsZero() {
+		return 0, false
+	}
+	return time.Until(p.deadline), true
+}
+
+// withoutDeadline returns a copy of the policy that ignores the overall
+// deadline, for the calls that have to be made even when it has passed.
+func (p *RetryPolicy) withoutDeadline() *RetryPolicy {
+	policy := *p
+	policy.deadline = time.Time{}
+	return &policy
+}
+
+func (p *RetryPolicy) expired() bool {
+	remaining, ok := p.remaining()
+	return ok && remaining <= 0
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable,
+// the attempts are exhausted or the deadline passes. It returns the number of
+// calls made and the last error of fn.
+func (p *RetryPolicy) do(fn func() error) (int, error) {
+	return p.doLogged(log.NewEntry(log.StandardLogger()), fn)
+}
+
+// doLogged is do, logging the retries to entry.
+func (p *RetryPolicy) doLogged(entry *log.Entry, fn func() error) (int, error) {
+	backoff := wait.Backoff{
+		Duration: p.InitialBackoff,
+		Factor:   p.BackoffFactor,
+		Jitter:   p.Jitter,
+		Steps:    p.Attempts,
+		Cap:      p.MaxBackoff,
+	}
+	var err error
+	attempts := 0
+	for {
+		if p.expired() {
+			if attempts == 0 {
+				return 0, errDeadlineExceeded
+			}
+			entry.WithField("attempt", attempts).Warningf("not retrying, %v", errDeadlineExceeded)
+			return attempts, err
+		}
+
+		attempts++
+		err = fn()
+		if classifyError(err) != ErrorClassRetryable || attempts >= p.Attempts {
+			return attempts, err
+		}
+
+		delay := backoff.Step()
+		if remaining, ok := p.remaining(); ok && delay > remaining {
+			delay = remaining
+		}
+		entry.WithField("attempt", attempts).Warningf("retrying in %v after transient error (attempt %d of %d): %v", delay, attempts, p.Attempts, err)
+		time.Sleep(delay)
+	}
+}
+
+// retryOnTransientError calls fn following the retry policy of the remover
+// and returns its last error.
+func (r *Remover) retryOnTransientError(fn func() error) error {
+	_, err := r.retry.do(fn)
+	return err
+}
@@ -0,0 +1,192 @@
+package remover
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// acknowledgeDataLossAnnotation, set to "true" on either Service Catalog
+// operator CR, lets the removal proceed while the catalog is still in use,
+// like Options.Force does.
+const acknowledgeDataLossAnnotation = "servicecatalog.openshift.io/acknowledge-data-loss"
+
+// NamespaceUsage counts the service catalog objects of a tenant namespace.
+type NamespaceUsage struct {
+	Namespace        string `json:"namespace"`
+	ServiceInstances int    `json:"serviceInstances"`
+	ServiceBindings  int    `json:"serviceBindings"`
+	ServiceBrokers   int    `json:"serviceBrokers"`
+}
+
+// CatalogUsage tells how much the cluster still uses Service Catalog.
+type CatalogUsage struct {
+	ClusterServiceBrokers int              `json:"clusterServiceBrokers"`
+	Namespaces            []NamespaceUsage `json:"namespaces,omitempty"`
+	// Unknown, when set, is why the usage could not be measured: the API
+	// group is registered but cannot be discovered, so that its objects are
+	// out of reach but still stored.
+	Unknown string `json:"unknown,omitempty"`
+}
+
+// inUse tells whether tenants still have instances or bindings, which the
+// removal would destroy. Brokers on their own hold no tenant data.
+func (u *CatalogUsage) inUse() bool {
+	for _, ns := range u.Namespaces {
+		if ns.ServiceInstances > 0 || ns.ServiceBindings > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// summary lists the affected tenants, for instance
+// "ns1 (2 instances, 1 bindings), ns2 (1 instances, 0 bindings)".
+func (u *CatalogUsage) summary() string {
+	var tenants []string
+	for _, ns := range u.Namespaces {
+		if ns.ServiceInstances > 0 || ns.ServiceBindings > 0 {
+			tenants = append(tenants, fmt.Sprintf("%s (%d instances, %d bindings)", ns.Namespace, ns.ServiceInstances, ns.ServiceBindings))
+		}
+	}
+	return strings.Join(tenants, ", ")
+}
+
+// measureCatalogUsage counts service instances, bindings and brokers per
+// namespace, along with the cluster service brokers. Nothing is counted when
+// the service catalog API is no longer registered: the objects are gone. When
+// it is registered but cannot be discovered, as happens while its API server
+// is down, the usage is unknown.
+func (r *Remover) measureCatalogUsage() (*CatalogUsage, error) {
+	var resources []schema.GroupVersionResource
+	err := r.retryOnTransientError(func() (err error) {
+		resources, err = discoverServedResources(r.discoveryClient, apiGroup{Name: serviceCatalogGroup})
+		return err
+	})
+	if undiscoverable, ok := err.(*undiscoverableGroupError); ok {
+		return &CatalogUsage{Unknown: undiscoverable.Error()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem discovering %s resources: %v", serviceCatalogGroup, err)
+	}
+
+	usage := &CatalogUsage{}
+	namespaces := map[string]*NamespaceUsage{}
+	for _, gvr := range resources {
+		switch gvr.Resource {
+		case "serviceinstances", "servicebindings", "servicebrokers", "clusterservicebrokers":
+		default:
+			continue
+		}
+		var list *unstructured.UnstructuredList
+		err := r.retryOnTransientError(func() (err error) {
+			list, err = r.dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("problem listing %s: %v", gvr.Resource, err)
+		}
+		for _, obj := range list.Items {
+			if gvr.Resource == "clusterservicebrokers" {
+				usage.ClusterServiceBrokers++
+				continue
+			}
+			ns, ok := namespaces[obj.GetNamespace()]
+			if !ok {
+				ns = &NamespaceUsage{Namespace: obj.GetNamespace()}
+				namespaces[obj.GetNamespace()] = ns
+			}
+			switch gvr.Resource {
+			case "serviceinstances":
+				ns.ServiceInstances++
+			case "servicebindings":
+				ns.ServiceBindings++
+			case "servicebrokers":
+				ns.ServiceBrokers++
+			}
+		}
+	}
+	for _, ns := range namespaces {
+		usage.Namespaces = append(usage.Namespaces, *ns)
+	}
+	sort.Slice(usage.Namespaces, func(i, j int) bool {
+		return usage.Namespaces[i].Namespace < usage.Namespaces[j].Namespace
+	})
+	return usage, nil
+}
+
+// dataLossAcknowledged tells whether acknowledgeDataLossAnnotation is set on
+// either Service Catalog operator CR.
+func (r *Remover) dataLossAcknowledged() (bool, error) {
+	var annotations []map[string]string
+	err := r.retryOnTransientError(func() error {
+		annotations = nil
+		controllerManagerConfig, err := r.operatorClient.ServiceCatalogControllerManagers().Get(r.controllerManager.crName, metav1.GetOptions{})
+		if err == nil {
+			annotations = append(annotations, controllerManagerConfig.Annotations)
+		} else if !apierrors.IsNotFound(err) {
+			return err
+		}
+		apiServerConfig, err := r.operatorClient.ServiceCatalogAPIServers().Get(r.apiServer.crName, metav1.GetOptions{})
+		if err == nil {
+			annotations = append(annotations, apiServerConfig.Annotations)
+		} else if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, a := range annotations {
+		if a[acknowledgeDataLossAnnotation] == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkCatalogUsage measures the usage of the catalog and returns, when it is
+// still in use or its usage is unknown and neither Options.Force nor
+// acknowledgeDataLossAnnotation acknowledge the data loss, the reason the
+// removal is blocked.
+func (r *Remover) checkCatalogUsage() (*CatalogUsage, string, error) {
+	usage, err := r.measureCatalogUsage()
+	if err != nil {
+		return nil, "", err
+	}
+	var inUse, remedy string
+	switch {
+	case len(usage.Unknown) > 0:
+		inUse = fmt.Sprintf("may still be in use, %s", usage.Unknown)
+		remedy = "Bring its API server back"
+	case usage.inUse():
+		inUse = fmt.Sprintf("is still in use by %s", usage.summary())
+		remedy = "Remove the instances and bindings"
+	default:
+		return usage, "", nil
+	}
+
+	if r.options.Force {
+		log.Warningf("Service Catalog %s, removing anyway because of --force", inUse)
+		return usage, "", nil
+	}
+	acknowledged, err := r.dataLossAcknowledged()
+	if err != nil {
+		return nil, "", err
+	}
+	if acknowledged {
+		log.Warningf("Service Catalog %s, removing anyway because of the %s annotation", inUse, acknowledgeDataLossAnnotation)
+		return usage, "", nil
+	}
+	return usage, fmt.Sprintf("Service Catalog %s. %s, or acknowledge the loss of the instances and bindings with --force or the %s=true annotation on the %s CR",
+		inUse, remedy, acknowledgeDataLossAnnotation, controllerManager.crKind), nil
+}
@@ -0,0 +1,266 @@
+package remover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type CheckResult string
+
+const (
+	CheckPassed CheckResult = "Passed"
+	CheckFailed CheckResult = "Failed"
+	// CheckPending means the object is gone but for its finalizers: it is
+	// being deleted.
+	CheckPending CheckResult = "Pending"
+)
+
+// VerificationCheck is a single item of the checklist of a verification.
+type VerificationCheck struct {
+	Description string      `json:"description"`
+	Result      CheckResult `json:"result"`
+	Message     string      `json:"message,omitempty"`
+}
+
+// Verification is the checklist telling whether Service Catalog is gone: the
+// same checks the e2e tests make, extended to every object of the inventory.
+type Verification struct {
+	// Passed is set when every check passed. Objects still being deleted do
+	// not pass.
+	Passed bool                `json:"passed"`
+	Checks []VerificationCheck `json:"checks"`
+}
+
+func (v *Verification) add(description string, result CheckResult, message string) {
+	v.Checks = append(v.Checks, VerificationCheck{Description: description, Result: result, Message: message})
+}
+
+// checkRemoved checks that the object of the inventory is gone.
+func (r *Remover) checkRemoved(v *Verification, obj inventoryObject) {
+	description := fmt.Sprintf("%s removed", describeObject(obj.kind, obj.namespace, obj.name))
+	var found *unstructured.Unstructured
+	err := r.retryOnTransientError(func() (err error) {
+		found, err = resourceClient(r.dynamicClient, obj.gvr, obj.namespace).Get(obj.name, metav1.GetOptions{})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+		v.add(description, CheckPassed, "")
+	case err != nil:
+		v.add(description, CheckFailed, fmt.Sprintf("problem getting it (%s): %v", classifyError(err), err))
+	case found.GetDeletionTimestamp() != nil:
+		v.add(description, CheckPending, fmt.Sprintf("being deleted, finalizers: %v", found.GetFinalizers()))
+	default:
+		v.add(description, CheckFailed, "still present")
+	}
+}
+
+// checkNoneLeft checks that none of the objects, and of the objects being
+// deleted, that selected picks is left.
+func checkNoneLeft(v *Verification, description string, objects, deleting []unstructured.Unstructured, selected func(gvk schema.GroupVersionKind) bool) {
+	var left, pending []string
+	for _, obj := range objects {
+		if selected(obj.GroupVersionKind()) {
+			left = append(left, objectName(obj))
+		}
+	}
+	for _, obj := range deleting {
+		if selected(obj.GroupVersionKind()) {
+			pending = append(pending, objectName(obj))
+		}
+	}
+	switch {
+	case len(left) > 0:
+		v.add(description, CheckFailed, "still present: "+strings.Join(append(left, pending...), ", "))
+	case len(pending) > 0:
+		v.add(description, CheckPending, "being deleted: "+strings.Join(pending, ", "))
+	default:
+		v.add(description, CheckPassed, "")
+	}
+}
+
+// checkSelectorRemoved checks that no object the label selector of obj
+// matches is left.
+func (r *Remover) checkSelectorRemoved(v *Verification, obj inventoryObject) {
+	description := fmt.Sprintf("No %s matching %s left", obj.kind, obj.labelSelector)
+	var list *unstructured.UnstructuredList
+	err := r.retryOnTransientError(func() (err error) {
+		list, err = resourceClient(r.dynamicClient, obj.gvr, obj.namespace).List(metav1.ListOptions{LabelSelector: obj.labelSelector})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+		v.add(description, CheckPassed, "")
+		return
+	case err != nil:
+		v.add(description, CheckFailed, fmt.Sprintf("problem listing them (%s): %v", classifyError(err), err))
+		return
+	}
+	var objects, deleting []unstructured.Unstructured
+	for _, item := range list.Items {
+		if item.GetDeletionTimestamp() != nil {
+			deleting = append(deleting, item)
+			continue
+		}
+		objects = append(objects, item)
+	}
+	checkNoneLeft(v, description, objects, deleting, func(schema.GroupVersionKind) bool { return true })
+}
+
+// checkGroupRemoved checks that no object, APIService or CRD of the group is
+// left.
+func (r *Remover) checkGroupRemoved(v *Verification, g apiGroup) {
+	var objects, deleting []unstructured.Unstructured
+	err := r.retryOnTransientError(func() (err error) {
+		objects, deleting, err = remainingResources(r.dynamicClient, r.discoveryClient, g)
+		return err
+	})
+	registrations := []struct {
+		description string
+		kind        string
+	}{
+		{fmt.Sprintf("No %s APIService left", g.Name), "APIService"},
+		{fmt.Sprintf("No %s CustomResourceDefinition left", g.Name), "CustomResourceDefinition"},
+	}
+	resourcesDescription := fmt.Sprintf("No %s resource left", g.Name)
+	if err != nil {
+		message := fmt.Sprintf("problem listing them (%s): %v", classifyError(err), err)
+		for _, registration := range registrations {
+			v.add(registration.description, CheckFailed, message)
+		}
+		v.add(resourcesDescription, CheckFailed, message)
+		return
+	}
+	for _, registration := range registrations {
+		kind := registration.kind
+		checkNoneLeft(v, registration.description, objects, deleting, func(gvk schema.GroupVersionKind) bool {
+			return gvk.Kind == kind
+		})
+	}
+	checkNoneLeft(v, resourcesDescription, objects, deleting, func(gvk schema.GroupVersionKind) bool {
+		return gvk.Group == g.Name
+	})
+}
+
+// checkRemovedNamespace checks that RemovedNamespaceName, which the payload
+// creates for the remover job, is present or, as the payload deletes it once
+// the job is done, deleted.
+func (r *Remover) checkRemovedNamespace(v *Verification) {
+	description := fmt.Sprintf("Namespace %s present or deleted by the payload", RemovedNamespaceName)
+	var namespace *corev1.Namespace
+	err := r.retryOnTransientError(func() (err error) {
+		namespace, err = r.kubeClient.CoreV1().Namespaces().Get(RemovedNamespaceName, metav1.GetOptions{})
+		return err
+	})
+	switch {
+	case apierrors.IsNotFound(err):
+		v.add(description, CheckPassed, "deleted by the payload")
+	case err != nil:
+		v.add(description, CheckFailed, fmt.Sprintf("problem getting it (%s): %v", classifyError(err), err))
+	case namespace.DeletionTimestamp != nil:
+		v.add(description, CheckPassed, "being deleted by the payload")
+	default:
+		v.add(description, CheckPassed, "")
+	}
+}
+
+// verify runs the checklist of the inventory against the cluster.
+func (r *Remover) verify(inv *inventory) *Verification {
+	v := &Verification{}
+
+	r.checkRemovedNamespace(v)
+	for _, obj := range inv.objects(r.options.KeepClusterOperator) {
+		switch {
+		case obj.policy.SkipVerify:
+		case len(obj.labelSelector) > 0:
+			r.checkSelectorRemoved(v, obj)
+		default:
+			r.checkRemoved(v, obj)
+		}
+	}
+	for _, g := range inv.apiGroups() {
+		r.checkGroupRemoved(v, g)
+	}
+
+	v.Passed = true
+	for _, check := range v.Checks {
+		if check.Result != CheckPassed {
+			v.Passed = false
+		}
+	}
+	return v
+}
+
+// verifyRemoval runs the checklist once everything was removed and records it
+// in the report. It does not change the outcome of the removal, which is that
+// of its steps: namespaces deleted without Options.WaitForDeletion, for one,
+// are usually still being deleted.
+func (r *Remover) verifyRemoval(report *Report, status *removalStatus, inv *inventory) {
+	status.progress(phaseVerifying, "Verifying that nothing of Service Catalog remains")
+	v := r.verify(inv)
+	for _, check := range v.Checks {
+		entry := log.WithFields(log.Fields{"phase": phaseVerifying, "outcome": check.Result})
+		switch check.Result {
+		case CheckPassed:
+			entry.Infof("%s: %s", check.Description, check.Result)
+		default:
+			entry.Warningf("%s: %s, %s", check.Description, check.Result, check.Message)
+		}
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.Verification = v
+}
+
+// Verify checks, without changing anything, that nothing the inventory lists
+// remains, returning the checklist the removal ends with.
+func (r *Remover) Verify() (*Verification, error) {
+	r.retry.start()
+	r.applyLogLevel()
+	inv, err := r.inventory()
+	if err != nil {
+		return nil, err
+	}
+	return r.verify(inv), nil
+}
+
+// PrintVerification writes the checklist to out in the given format, text or
+// json.
+func PrintVerification(out io.Writer, v *Verification, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "text":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tMESSAGE")
+	for _, check := range v.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Description, check.Result, check.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if v.Passed {
+		_, err := fmt.Fprintln(out, "Nothing of Service Catalog remains.")
+		return err
+	}
+	return nil
+}
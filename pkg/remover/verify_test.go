@@ -0,0 +1,119 @@
+package remover
+
+import (
+	"fmt"
+	"testing"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestVerify(t *testing.T) {
+	clusterOperator := newObject("config.openshift.io/v1", "ClusterOperator", "", controllerManager.clusterOperatorName)
+
+	namespace := newObject("v1", "Namespace", "", apiServer.operandNamespace)
+	namespace.SetFinalizers([]string{"kubernetes"})
+	namespace.Object["metadata"].(map[string]interface{})["deletionTimestamp"] = "2020-01-01T00:00:00Z"
+
+	apiService := newRegistration("apiregistration.k8s.io/v1", "APIService", "v1beta1."+serviceCatalogGroup)
+
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	clients.dynamic = newFakeDynamicClient(clusterOperator, namespace, apiService)
+
+	v, err := clients.remover(testOptions()).Verify()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if v.Passed {
+		t.Errorf("expected the verification to fail")
+	}
+	expected := map[string]CheckResult{
+		"Namespace " + RemovedNamespaceName + " present or deleted by the payload": CheckPassed,
+		"Namespace " + controllerManager.operandNamespace + " removed":             CheckPassed,
+		"Namespace " + apiServer.operandNamespace + " removed":                     CheckPending,
+		"ClusterOperator " + controllerManager.clusterOperatorName + " removed":    CheckFailed,
+		"ClusterOperator " + apiServer.clusterOperatorName + " removed":            CheckPassed,
+		"No " + serviceCatalogGroup + " APIService left":                           CheckFailed,
+		"No " + serviceCatalogGroup + " CustomResourceDefinition left":             CheckPassed,
+		"No " + serviceCatalogGroup + " resource left":                             CheckPassed,
+	}
+	results := map[string]CheckResult{}
+	for _, check := range v.Checks {
+		results[check.Description] = check.Result
+	}
+	for description, result := range expected {
+		if results[description] != result {
+			t.Errorf("expected check %q to be %s, got %q", description, result, results[description])
+		}
+	}
+}
+
+func TestVerifyRemovedNamespace(t *testing.T) {
+	tests := []struct {
+		name            string
+		setup           func(clients *fakeClients) error
+		expectedResult  CheckResult
+		expectedMessage string
+	}{
+		{
+			name:           "present",
+			setup:          func(*fakeClients) error { return nil },
+			expectedResult: CheckPassed,
+		},
+		{
+			name: "being deleted by the payload",
+			setup: func(clients *fakeClients) error {
+				namespace, err := clients.kube.CoreV1().Namespaces().Get(RemovedNamespaceName, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				now := metav1.Now()
+				namespace.DeletionTimestamp = &now
+				_, err = clients.kube.CoreV1().Namespaces().Update(namespace)
+				return err
+			},
+			expectedResult:  CheckPassed,
+			expectedMessage: "being deleted by the payload",
+		},
+		{
+			name: "deleted by the payload",
+			setup: func(clients *fakeClients) error {
+				return clients.kube.CoreV1().Namespaces().Delete(RemovedNamespaceName, nil)
+			},
+			expectedResult:  CheckPassed,
+			expectedMessage: "deleted by the payload",
+		},
+		{
+			name: "not readable",
+			setup: func(clients *fakeClients) error {
+				clients.kube.PrependReactor("get", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, RemovedNamespaceName, fmt.Errorf("denied"))
+				})
+				return nil
+			},
+			expectedResult:  CheckFailed,
+			expectedMessage: "problem getting it (Forbidden): namespaces \"" + RemovedNamespaceName + "\" is forbidden: denied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			if err := test.setup(clients); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v := &Verification{}
+
+			clients.remover(testOptions()).checkRemovedNamespace(v)
+
+			if len(v.Checks) != 1 || v.Checks[0].Result != test.expectedResult || v.Checks[0].Message != test.expectedMessage {
+				t.Errorf("expected a check %s with message %q, got %+v", test.expectedResult, test.expectedMessage, v.Checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package remover
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const actionWaitForDeletion = "WaitForDeletion"
+
+// deletionPollInterval is how often a deleted object is checked while
+// waiting for it to disappear.
+var deletionPollInterval = 2 * time.Second
+
+// stuckError is returned for an object that was still present when the wait
+// for its deletion timed out.
+type stuckError struct {
+	reason string
+}
+
+func (e *stuckError) Error() string {
+	return e.reason
+}
+
+// describeStuckObject explains why the object has not disappeared yet.
+func describeStuckObject(obj *unstructured.Unstructured) string {
+	deletionTimestamp := obj.GetDeletionTimestamp()
+	if deletionTimestamp == nil {
+		return "the object is not being deleted, it may have been recreated"
+	}
+	if finalizers := obj.GetFinalizers(); len(finalizers) > 0 {
+		return fmt.Sprintf("deletion requested at %s is waiting on finalizers %v", deletionTimestamp.UTC().Format(time.RFC3339), finalizers)
+	}
+	return fmt.Sprintf("deletion requested at %s has not completed", deletionTimestamp.UTC().Format(time.RFC3339))
+}
+
+// deletionDeadline returns when the wait for objects deleted together ends:
+// once Options.DeletionTimeout passed, for all of them as they are deleted
+// concurrently, or at the overall deadline if that comes first. Waits chained
+// along the phases of the inventory so never outlast the overall deadline.
+func (r *Remover) deletionDeadline() time.Time {
+	timeout := r.options.DeletionTimeout
+	if remaining, ok := r.retry.remaining(); ok && remaining < timeout {
+		timeout = remaining
+	}
+	return time.Now().Add(timeout)
+}
+
+// waitForDeletion polls the object until it is gone or the deadline, from
+// deletionDeadline, passes. Errors getting the object are retried until then.
+// Without any time left the object is checked once.
+func (r *Remover) waitForDeletion(gvr schema.GroupVersionResource, namespace, name string, deadline time.Time) error {
+	timeout := time.Until(deadline)
+
+	var last *unstructured.Unstructured
+	var lastErr error
+	gone := func() (bool, error) {
+		obj, err := resourceClient(r.dynamicClient, gvr, namespace).Get(name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			return true, nil
+		case err != nil:
+			log.Warningf("problem checking whether %s has been removed: %v", describeObject(gvr.Resource, namespace, name), err)
+			lastErr = err
+			return false, nil
+		}
+		last = obj
+		return false, nil
+	}
+	var err error
+	if timeout > 0 {
+		err = wait.PollImmediate(deletionPollInterval, timeout, gone)
+	} else {
+		// PollImmediate would wait forever given no timeout
+		timeout = 0
+		if done, _ := gone(); !done {
+			err = wait.ErrWaitTimeout
+		}
+	}
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	if last == nil {
+		return &stuckError{reason: fmt.Sprintf("still present after %v, last error: %v", timeout, lastErr)}
+	}
+	return &stuckError{reason: fmt.Sprintf("still present after %v: %s", timeout, describeStuckObject(last))}
+}
+
+// trackDeletion waits, until the deadline, for a deleted object to disappear
+// and records the outcome as a step of the report.
+func (r *Remover) trackDeletion(report *Report, gvr schema.GroupVersionResource, kind, namespace, name string, deadline time.Time) error {
+	return report.track(kind, namespace, name, actionWaitForDeletion, func() error {
+		return r.waitForDeletion(gvr, namespace, name, deadline)
+	})
+}
@@ -0,0 +1,97 @@
+package remover
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	operatorapiv1 "github.com/openshift/api/operator/v1"
+)
+
+func TestWaitForDeletionWithoutTimeLeft(t *testing.T) {
+	tests := []struct {
+		name            string
+		deletionTimeout time.Duration
+		deadline        time.Duration
+	}{
+		{
+			name: "no deletion timeout",
+		},
+		{
+			name:            "overall deadline passed",
+			deletionTimeout: time.Minute,
+			deadline:        -time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+			clients.dynamic = newFakeDynamicClient(newObject("v1", "Namespace", "", controllerManager.operandNamespace))
+			options := testOptions()
+			options.DeletionTimeout = test.deletionTimeout
+			r := clients.remover(options)
+			if test.deadline != 0 {
+				r.retry.deadline = time.Now().Add(test.deadline)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- r.waitForDeletion(namespaceResource, "", controllerManager.operandNamespace, r.deletionDeadline())
+			}()
+
+			select {
+			case err := <-done:
+				if _, ok := err.(*stuckError); !ok || !strings.Contains(err.Error(), "still present after 0s") {
+					t.Errorf("expected the namespace to be reported stuck, got %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("expected the namespace to be checked once, still waiting")
+			}
+		})
+	}
+}
+
+func TestValidateDeletionTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		options := DefaultOptions()
+		options.DeletionTimeout = timeout
+		if err := options.Validate(); err == nil {
+			t.Errorf("expected deletion timeout %v to be refused", timeout)
+		}
+	}
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunBoundsPhaseWaitsByDeadline(t *testing.T) {
+	clients := newFakeClients(operatorapiv1.Removed, operatorapiv1.Removed)
+	// the namespaces never terminate
+	clients.dynamic = newFakeDynamicClient(
+		newObject("v1", "Namespace", "", controllerManager.operatorNamespace),
+		newObject("v1", "Namespace", "", controllerManager.operandNamespace),
+		newObject("v1", "Namespace", "", apiServer.operatorNamespace),
+		newObject("v1", "Namespace", "", apiServer.operandNamespace),
+	)
+	options := testOptions()
+	options.DeletionTimeout = time.Minute
+	options.RetryPolicy.Timeout = 500 * time.Millisecond
+
+	done := make(chan *Report)
+	go func() { done <- clients.remover(options).Run() }()
+
+	select {
+	case report := <-done:
+		if report.Outcome != ReportPartiallyFailed {
+			t.Errorf("expected outcome %s, got %s: %s", ReportPartiallyFailed, report.Outcome, report.Message)
+		}
+		for _, step := range report.Steps {
+			if step.Action == actionWaitForDeletion && step.Outcome != StepStuck && step.ErrorClass != ErrorClassRetryable {
+				t.Errorf("expected the wait for %s to be stuck or to miss the deadline, got %s: %s", step.Name, step.Outcome, step.Error)
+			}
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("expected the waits of the phases to end at the overall deadline, still waiting")
+	}
+}
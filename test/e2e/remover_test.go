@@ -2,18 +2,29 @@ package e2e
 
 import (
 	"testing"
+	"time"
 
 	operatorclient "github.com/openshift/client-go/operator/clientset/versioned"
 	test "github.com/openshift/cluster-svcat-controller-manager-operator/test/library"
 	log "github.com/sirupsen/logrus"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 var removerNamespaceName = "openshift-service-catalog-removed"
 var operatorNamespaceName = "openshift-service-catalog-controller-manager-operator"
+var operandNamespaceName = "openshift-service-catalog-controller-manager"
+var apiServerOperatorNamespaceName = "openshift-service-catalog-apiserver-operator"
+var apiServerOperandNamespaceName = "openshift-service-catalog-apiserver"
+var serviceCatalogAPIServiceName = "v1beta1.servicecatalog.k8s.io"
+
+const namespaceRemovalInterval = 5 * time.Second
+const namespaceRemovalTimeout = 5 * time.Minute
 
 func TestRemoverNamespace(t *testing.T) {
 	kubeConfig, err := test.NewClientConfigForTest()
@@ -32,6 +43,22 @@ func TestRemoverNamespace(t *testing.T) {
 }
 
 func TestOperatorNamespaceRemoval(t *testing.T) {
+	testNamespaceRemoval(t, operatorNamespaceName)
+}
+
+func TestOperandNamespaceRemoval(t *testing.T) {
+	testNamespaceRemoval(t, operandNamespaceName)
+}
+
+func TestAPIServerOperatorNamespaceRemoval(t *testing.T) {
+	testNamespaceRemoval(t, apiServerOperatorNamespaceName)
+}
+
+func TestAPIServerOperandNamespaceRemoval(t *testing.T) {
+	testNamespaceRemoval(t, apiServerOperandNamespaceName)
+}
+
+func testNamespaceRemoval(t *testing.T, namespaceName string) {
 	kubeConfig, err := test.NewClientConfigForTest()
 	if err != nil {
 		t.Fatal(err)
@@ -41,11 +68,18 @@ func TestOperatorNamespaceRemoval(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = kubeClient.CoreV1().Namespaces().Get(operatorNamespaceName, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
+	// namespace termination can take a while after the remover deleted it
+	err = wait.PollImmediate(namespaceRemovalInterval, namespaceRemovalTimeout, func() (bool, error) {
+		_, err := kubeClient.CoreV1().Namespaces().Get(namespaceName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err == wait.ErrWaitTimeout {
+		t.Fatalf("%s namespace was not removed", namespaceName)
+	} else if err != nil {
 		t.Fatal(err)
-	} else if err == nil {
-		t.Fatalf("%s namespace was not removed", operatorNamespaceName)
 	}
 }
 
@@ -69,3 +103,41 @@ func TestOperatorCRRemoval(t *testing.T) {
 	}
 
 }
+
+func TestAPIServerCRRemoval(t *testing.T) {
+	kubeConfig, err := test.NewClientConfigForTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operatorClient, err := operatorclient.NewForConfig(kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = operatorClient.OperatorV1().ServiceCatalogAPIServers().Get("cluster", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("ServiceCatalogAPIServer CR was not removed")
+	}
+}
+
+func TestServiceCatalogAPIServiceRemoval(t *testing.T) {
+	kubeConfig, err := test.NewClientConfigForTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiServices := schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+	_, err = dynamicClient.Resource(apiServices).Get(serviceCatalogAPIServiceName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatalf("%s APIService was not removed", serviceCatalogAPIServiceName)
+	}
+}